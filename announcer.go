@@ -0,0 +1,159 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * announcer.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// announce sends a message to MumbleDJ's channel. If the event was caused by a command, the
+// sender receives a copy of the message when they are not in the channel.
+func announce(sender CommandSender, message string) {
+	if sender != nil {
+		dj.SendChannelMessage(sender, message)
+	} else if dj.client != nil && dj.client.Self != nil && dj.client.Self.Channel != nil {
+		dj.client.Self.Channel.Send(message, false)
+	}
+}
+
+// ChatAnnouncer announces events in MumbleDJ's channel.
+var ChatAnnouncer = Listener{
+	SongQueued: func(e *SongQueuedEvent) {
+		announce(e.Sender, dj.messages.Render(SONG_ADDED_HTML, SongMessageData(e.Song)))
+	},
+	PlaylistQueued: func(e *PlaylistQueuedEvent) {
+		announce(e.Sender, dj.messages.Render(PLAYLIST_ADDED_HTML, MessageData{Submitter: e.Sender.Name(), Playlist: e.Playlist.Title()}))
+	},
+	SongRemoved: func(e *SongRemovedEvent) {
+		data := SongMessageData(e.Song)
+		data.User = e.Sender.Name()
+		announce(e.Sender, dj.messages.Render(SONG_REMOVED_HTML, data))
+	},
+	SongStarted: func(e *SongStartedEvent) {
+		announce(nil, dj.messages.Render(NOW_PLAYING_HTML, SongMessageData(e.Song)))
+	},
+	SkipVoted: func(e *SkipVotedEvent) {
+		if e.Playlist {
+			announce(e.Sender, dj.messages.Render(PLAYLIST_SKIP_ADDED_HTML, MessageData{User: e.Sender.Name()}))
+		} else {
+			announce(e.Sender, dj.messages.Render(SKIP_ADDED_HTML, MessageData{User: e.Sender.Name()}))
+		}
+	},
+	SongSkipped: func(e *SongSkippedEvent) {
+		if e.Forced {
+			announce(e.Sender, dj.messages.Render(ADMIN_SONG_SKIP_MSG, MessageData{}))
+		} else if e.BySubmitter {
+			announce(e.Sender, dj.messages.Render(SUBMITTER_SKIP_HTML, MessageData{User: e.Sender.Name()}))
+		} else {
+			announce(e.Sender, dj.messages.Render(SKIP_ADDED_HTML, MessageData{User: e.Sender.Name()}))
+			announce(e.Sender, dj.messages.Render(SONG_SKIPPED_HTML, MessageData{}))
+		}
+	},
+	PlaylistSkipped: func(e *PlaylistSkippedEvent) {
+		if e.Forced {
+			announce(e.Sender, dj.messages.Render(ADMIN_PLAYLIST_SKIP_MSG, MessageData{}))
+		} else if e.BySubmitter {
+			announce(e.Sender, dj.messages.Render(PLAYLIST_SUBMITTER_SKIP_HTML, MessageData{User: e.Sender.Name()}))
+		} else {
+			announce(e.Sender, dj.messages.Render(PLAYLIST_SKIP_ADDED_HTML, MessageData{User: e.Sender.Name()}))
+			announce(e.Sender, dj.messages.Render(PLAYLIST_SKIPPED_HTML, MessageData{}))
+		}
+	},
+	PlaybackPaused: func(e *PlaybackPausedEvent) {
+		announce(e.Sender, dj.messages.Render(PAUSED_HTML, MessageData{User: e.Sender.Name()}))
+	},
+	PlaybackResumed: func(e *PlaybackResumedEvent) {
+		announce(e.Sender, dj.messages.Render(RESUMED_HTML, MessageData{User: e.Sender.Name()}))
+	},
+	VolumeChanged: func(e *VolumeChangedEvent) {
+		announce(e.Sender, dj.messages.Render(VOLUME_SUCCESS_HTML, MessageData{User: e.Sender.Name(), Volume: e.Volume}))
+	},
+	QueueReset: func(e *QueueResetEvent) {
+		announce(e.Sender, dj.messages.Render(QUEUE_RESET_HTML, MessageData{User: e.Sender.Name()}))
+	},
+	DownloadFailed: func(e *DownloadFailedEvent) {
+		// Failures for songs added by a command are only reported to the user that added them.
+		if e.Sender != nil {
+			dj.SendPrivateMessage(e.Sender, dj.messages.Render(AUDIO_FAIL_MSG, MessageData{Reason: downloadReason(e.Err)}))
+		} else {
+			announce(nil, dj.messages.Render(AUDIO_FAIL_MSG, MessageData{Reason: downloadReason(e.Err)}))
+		}
+	},
+}
+
+// EventLogger logs events.
+var EventLogger = Listener{
+	SongQueued: func(e *SongQueuedEvent) {
+		logger.WithFields(songFields(e.Song)).WithField("user", e.Sender.Name()).Info("Song added to the queue.")
+	},
+	PlaylistQueued: func(e *PlaylistQueuedEvent) {
+		logger.WithFields(logrus.Fields{
+			"user":     e.Sender.Name(),
+			"playlist": e.Playlist.ID(),
+			"title":    e.Playlist.Title(),
+			"songs":    e.Songs,
+		}).Info("Playlist added to the queue.")
+	},
+	SongRemoved: func(e *SongRemovedEvent) {
+		logger.WithFields(songFields(e.Song)).WithField("user", e.Sender.Name()).Info("Song removed from the queue.")
+	},
+	SongStarted: func(e *SongStartedEvent) {
+		logger.WithFields(songFields(e.Song)).WithField("submitter", e.Song.Submitter()).Info("Song started playing.")
+	},
+	SongFinished: func(e *SongFinishedEvent) {
+		logger.WithFields(songFields(e.Song)).Debug("Song finished playing.")
+	},
+	SkipVoted: func(e *SkipVotedEvent) {
+		logger.WithFields(songFields(e.Song)).WithFields(logrus.Fields{
+			"user":         e.Sender.Name(),
+			"skipPlaylist": e.Playlist,
+		}).Debug("Skip vote added.")
+	},
+	SongSkipped: func(e *SongSkippedEvent) {
+		logger.WithFields(songFields(e.Song)).WithFields(logrus.Fields{
+			"user":   e.Sender.Name(),
+			"forced": e.Forced,
+		}).Info("Song skipped.")
+	},
+	PlaylistSkipped: func(e *PlaylistSkippedEvent) {
+		logger.WithFields(logrus.Fields{
+			"user":     e.Sender.Name(),
+			"playlist": e.Playlist.ID(),
+			"title":    e.Playlist.Title(),
+			"forced":   e.Forced,
+		}).Info("Playlist skipped.")
+	},
+	PlaybackPaused: func(e *PlaybackPausedEvent) {
+		logger.WithFields(songFields(e.Song)).WithField("user", e.Sender.Name()).Info("Playback paused.")
+	},
+	PlaybackResumed: func(e *PlaybackResumedEvent) {
+		logger.WithFields(songFields(e.Song)).WithField("user", e.Sender.Name()).Info("Playback resumed.")
+	},
+	VolumeChanged: func(e *VolumeChangedEvent) {
+		logger.WithFields(logrus.Fields{
+			"user":   e.Sender.Name(),
+			"volume": e.Volume,
+		}).Info("Volume changed.")
+	},
+	QueueReset: func(e *QueueResetEvent) {
+		logger.WithField("user", e.Sender.Name()).Info("Queue cleared.")
+	},
+	DownloadFailed: func(e *DownloadFailedEvent) {
+		entry := logger.WithFields(songFields(e.Song)).WithError(e.Err).WithField("reason", downloadReason(e.Err))
+		if failure, ok := e.Err.(*DownloadError); ok {
+			entry = entry.WithFields(logrus.Fields{
+				"attempts": failure.Attempts,
+				"output":   failure.Output,
+			})
+		}
+		if e.Sender != nil {
+			entry = entry.WithField("user", e.Sender.Name())
+		}
+		entry.Error("Song download failed.")
+	},
+}
@@ -8,32 +8,48 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-// ByAge is a type that holds file information for the cache items.
-type ByAge []os.FileInfo
-
-func (a ByAge) Len() int {
-	return len(a)
-}
-func (a ByAge) Swap(i, j int) {
-	a[i], a[j] = a[j], a[i]
+// CacheEntry holds the information recorded in the cache index for a song in the cache.
+type CacheEntry struct {
+	Service    string    `json:"service"`
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Filename   string    `json:"filename"`
+	Size       int64     `json:"size"`
+	Added      time.Time `json:"added"`
+	LastPlayed time.Time `json:"last_played"`
+	PlayCount  int       `json:"play_count"`
+	Pinned     bool      `json:"pinned"`
 }
-func (a ByAge) Less(i, j int) bool {
-	return time.Since(a[i].ModTime()) < time.Since(a[j].ModTime())
+
+// LastUsed returns the time the song was last played, or the time it was added to the cache if
+// it has not been played since.
+func (e *CacheEntry) LastUsed() time.Time {
+	if e.LastPlayed.After(e.Added) {
+		return e.LastPlayed
+	}
+	return e.Added
 }
 
 // SongCache is a struct that holds the number of songs currently cached and
-// their combined file size.
+// their combined file size, along with the index of the songs in the cache. The index is
+// stored in cache.json in the state directory so that play counts and pins survive restarts.
 type SongCache struct {
 	NumSongs      int
 	TotalFileSize int64
+	stopExpiry    chan bool
+	mutex         sync.Mutex
+	entries       map[string]*CacheEntry
 }
 
 // NewSongCache creates an empty SongCache.
@@ -41,30 +57,258 @@ func NewSongCache() *SongCache {
 	newCache := &SongCache{
 		NumSongs:      0,
 		TotalFileSize: 0,
+		entries:       make(map[string]*CacheEntry),
 	}
 	return newCache
 }
 
+// cacheIndexPath returns the path of the cache index.
+func cacheIndexPath() string {
+	return statePath("cache.json")
+}
+
+// Load reads the cache index and reconciles it with the songs directory. Songs that are in the
+// index but no longer on disk are removed from it, songs on disk that are missing from the index
+// are added to it, and the recorded sizes are corrected.
+func (c *SongCache) Load() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[string]*CacheEntry)
+	if contents, err := ioutil.ReadFile(cacheIndexPath()); err == nil {
+		var entries []*CacheEntry
+		if err := json.Unmarshal(contents, &entries); err != nil {
+			logger.WithError(err).Warn("The cache index is not valid, so it will be rebuilt.")
+		}
+		for _, entry := range entries {
+			c.entries[entry.Filename] = entry
+		}
+	} else if !os.IsNotExist(err) {
+		logger.WithError(err).Warn("Could not read the cache index, so it will be rebuilt.")
+	}
+
+	onDisk := make(map[string]bool)
+	songs, _ := ioutil.ReadDir(dj.paths.Songs)
+	for _, song := range songs {
+		if song.IsDir() || isPartialDownload(song.Name()) {
+			continue
+		}
+		onDisk[song.Name()] = true
+		if entry, ok := c.entries[song.Name()]; ok {
+			if entry.Size != song.Size() {
+				logger.WithField("song", entry.ID).Info("Corrected the size of a song in the cache index.")
+				entry.Size = song.Size()
+			}
+		} else {
+			c.entries[song.Name()] = &CacheEntry{
+				Service:  "YouTube",
+				ID:       strings.TrimSuffix(song.Name(), filepath.Ext(song.Name())),
+				Filename: song.Name(),
+				Size:     song.Size(),
+				Added:    song.ModTime(),
+			}
+		}
+	}
+	for filename, entry := range c.entries {
+		if !onDisk[filename] {
+			logger.WithField("song", entry.ID).Info("Removed a song that is no longer on disk from the cache index.")
+			delete(c.entries, filename)
+		}
+	}
+	c.save()
+}
+
+// isPartialDownload returns whether filename belongs to a download that has not finished, or to
+// a downloaded song that has not yet been transcoded.
+func isPartialDownload(filename string) bool {
+	for _, suffix := range []string{".part", ".ytdl", ".source"} {
+		if strings.HasSuffix(filename, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the cache index to disk. Must be called while c.mutex is held.
+func (c *SongCache) save() {
+	entries := make([]*CacheEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	sort.Sort(byLastUsed(entries))
+	contents, err := json.MarshalIndent(entries, "", "\t")
+	if err == nil {
+		path := cacheIndexPath()
+		if err = ioutil.WriteFile(path+".tmp", contents, 0600); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Error("An error occurred while saving the cache index.")
+	}
+}
+
+// byLastUsed sorts cache entries from the least recently used to the most recently used.
+type byLastUsed []*CacheEntry
+
+func (a byLastUsed) Len() int {
+	return len(a)
+}
+func (a byLastUsed) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a byLastUsed) Less(i, j int) bool {
+	if a[i].LastUsed().Equal(a[j].LastUsed()) {
+		return a[i].ID < a[j].ID
+	}
+	return a[i].LastUsed().Before(a[j].LastUsed())
+}
+
+// Add records a song that has just been downloaded into the cache.
+func (c *SongCache) Add(s Song) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	info, err := os.Stat(songPath(s.Filename()))
+	if err != nil {
+		return
+	}
+	entry, ok := c.entries[s.Filename()]
+	if !ok {
+		entry = &CacheEntry{Filename: s.Filename(), Added: time.Now()}
+		c.entries[s.Filename()] = entry
+	}
+	entry.Service = s.Service()
+	entry.ID = s.ID()
+	entry.Title = s.Title()
+	entry.Size = info.Size()
+	c.save()
+}
+
+// Played records that a song in the cache has started playing.
+func (c *SongCache) Played(s Song) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.entries[s.Filename()]; ok {
+		entry.Title = s.Title()
+		entry.LastPlayed = time.Now()
+		entry.PlayCount++
+		c.save()
+	}
+}
+
+// Pin sets whether the song with the given ID is pinned. Pinned songs are never removed from
+// the cache when they expire or the cache is full. The entry for the song is returned.
+func (c *SongCache) Pin(id string, pinned bool) (*CacheEntry, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, entry := range c.entries {
+		if entry.ID == id {
+			entry.Pinned = pinned
+			c.save()
+			return entry, nil
+		}
+	}
+	return nil, errors.New("The song is not in the cache.")
+}
+
+// Entries returns a copy of every entry in the cache index, from the most recently used to the
+// least recently used.
+func (c *SongCache) Entries() []CacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entries := make([]*CacheEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	sort.Sort(sort.Reverse(byLastUsed(entries)))
+	copies := make([]CacheEntry, len(entries))
+	for i, entry := range entries {
+		copies[i] = *entry
+	}
+	return copies
+}
+
+// Has returns whether the song with the given ID is in the cache.
+func (c *SongCache) Has(id string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, entry := range c.entries {
+		if entry.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Purge removes every song from the cache for which match returns true, except for songs that are
+// in the queue or being downloaded. The number of songs removed and their total size are
+// returned.
+func (c *SongCache) Purge(match func(entry *CacheEntry) bool) (int, int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	removed := 0
+	var size int64
+	for _, entry := range c.entries {
+		if match(entry) && !c.inUse(entry.Filename) {
+			if err := c.remove(entry); err != nil {
+				logger.WithField("song", entry.ID).WithError(err).Error("An error occurred while purging a song from the cache.")
+				continue
+			}
+			removed++
+			size += entry.Size
+		}
+	}
+	c.save()
+	return removed, size
+}
+
+// Clear empties the cache index. It is used once the songs directory has been deleted.
+func (c *SongCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]*CacheEntry)
+	c.save()
+}
+
+// remove deletes a song from the cache and the cache index. Must be called while c.mutex is
+// held.
+func (c *SongCache) remove(entry *CacheEntry) error {
+	if err := os.Remove(songPath(entry.Filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(c.entries, entry.Filename)
+	return nil
+}
+
+// inUse returns whether the song stored in filename is needed by a song in the queue or is being
+// downloaded, in which case it must not be removed from the cache. It is called from the expiry
+// and download goroutines, so the queue is checked through Queued rather than walked.
+func (c *SongCache) inUse(filename string) bool {
+	return downloads.Has(filename) || dj.queue.Queued(filename)
+}
+
 // GetNumSongs returns the number of songs currently cached.
 func (c *SongCache) GetNumSongs() int {
-	songs, _ := ioutil.ReadDir(fmt.Sprintf("%s/.mumbledj/songs", dj.homeDir))
-	return len(songs)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
 }
 
 // GetCurrentTotalFileSize calculates the total file size of the files within
 // the cache and returns it.
 func (c *SongCache) GetCurrentTotalFileSize() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	var totalSize int64
-	songs, _ := ioutil.ReadDir(fmt.Sprintf("%s/.mumbledj/songs", dj.homeDir))
-	for _, song := range songs {
-		totalSize += song.Size()
+	for _, entry := range c.entries {
+		totalSize += entry.Size
 	}
 	return totalSize
 }
 
 // CheckMaximumDirectorySize checks the cache directory to determine if the filesize
-// of the songs within exceed the user-specified size limit. If so, the oldest files
-// get cleared until it is no longer exceeding the limit.
+// of the songs within exceed the user-specified size limit. If so, the least recently
+// used songs get cleared until it is no longer exceeding the limit.
 func (c *SongCache) CheckMaximumDirectorySize() {
 	for c.GetCurrentTotalFileSize() > (dj.conf.Cache.MaximumSize * 1048576) {
 		if err := c.ClearOldest(); err != nil {
@@ -79,35 +323,72 @@ func (c *SongCache) Update() {
 	c.TotalFileSize = c.GetCurrentTotalFileSize()
 }
 
-// ClearExpired clears cache items that are older than the cache period set within
-// the user configuration.
-func (c *SongCache) ClearExpired() {
-	for range time.Tick(5 * time.Minute) {
-		songs, _ := ioutil.ReadDir(fmt.Sprintf("%s/.mumbledj/songs", dj.homeDir))
-		for _, song := range songs {
-			hours := time.Since(song.ModTime()).Hours()
-			if hours >= dj.conf.Cache.ExpireTime {
-				if dj.queue.Len() > 0 {
-					if (dj.queue.CurrentSong().Filename()) != song.Name() {
-						os.Remove(fmt.Sprintf("%s/.mumbledj/songs/%s", dj.homeDir, song.Name()))
+// StartExpiry starts clearing expired cache items in the background, unless this is already
+// being done.
+func (c *SongCache) StartExpiry() {
+	if c.stopExpiry == nil {
+		c.stopExpiry = make(chan bool)
+		go c.ClearExpired(c.stopExpiry)
+	}
+}
+
+// StopExpiry stops clearing expired cache items in the background.
+func (c *SongCache) StopExpiry() {
+	if c.stopExpiry != nil {
+		close(c.stopExpiry)
+		c.stopExpiry = nil
+	}
+}
+
+// ClearExpired clears cache items that have not been played for longer than the cache period
+// set within the user configuration every 5 minutes, until stop is closed. Pinned songs are
+// never cleared.
+func (c *SongCache) ClearExpired(stop chan bool) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.mutex.Lock()
+			for _, entry := range c.entries {
+				if !entry.Pinned && !c.inUse(entry.Filename) && time.Since(entry.LastUsed()).Hours() >= dj.conf.Cache.ExpireTime {
+					if err := c.remove(entry); err != nil {
+						logger.WithField("song", entry.ID).WithError(err).Error("An error occurred while removing an expired song from the cache.")
 					}
-				} else {
-					os.Remove(fmt.Sprintf("%s/.mumbledj/songs/%s", dj.homeDir, song.Name()))
 				}
 			}
+			c.save()
+			c.mutex.Unlock()
 		}
 	}
 }
 
-// ClearOldest deletes the oldest item in the cache.
+// ClearOldest deletes the least recently used item in the cache that is not pinned or
+// currently needed.
 func (c *SongCache) ClearOldest() error {
-	songs, _ := ioutil.ReadDir(fmt.Sprintf("%s/.mumbledj/songs", dj.homeDir))
-	sort.Sort(ByAge(songs))
-	if dj.queue.Len() > 0 {
-		if (dj.queue.CurrentSong().Filename()) != songs[0].Name() {
-			return os.Remove(fmt.Sprintf("%s/.mumbledj/songs/%s", dj.homeDir, songs[0].Name()))
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var oldest *CacheEntry
+	for _, entry := range c.entries {
+		if !entry.Pinned && !c.inUse(entry.Filename) && (oldest == nil || entry.LastUsed().Before(oldest.LastUsed())) {
+			oldest = entry
 		}
-		return errors.New("Song is currently playing.")
 	}
-	return os.Remove(fmt.Sprintf("%s/.mumbledj/songs/%s", dj.homeDir, songs[0].Name()))
+	if oldest == nil {
+		return errors.New("There are no songs in the cache that can be removed.")
+	}
+	err := c.remove(oldest)
+	c.save()
+	return err
+}
+
+// CacheRecorder records each song that starts playing in the cache index.
+var CacheRecorder = Listener{
+	SongStarted: func(e *SongStartedEvent) {
+		if dj.conf.Cache.Enabled {
+			dj.cache.Played(e.Song)
+		}
+	},
 }
@@ -10,18 +10,55 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/layeh/gumble/gumble"
+	"github.com/sirupsen/logrus"
 )
 
+// CommandSender is the source of a command, such as a Mumble user or a client of the HTTP API.
+// Replies to the command are sent back through it.
+type CommandSender interface {
+	Name() string
+	Send(message string)
+	InChannel() bool
+}
+
+// MumbleSender is a CommandSender for commands sent as Mumble text messages.
+type MumbleSender struct {
+	user *gumble.User
+}
+
+// Name returns the name of the Mumble user.
+func (s *MumbleSender) Name() string {
+	return s.user.Name
+}
+
+// Send sends a private message to the Mumble user if they are still connected to the server.
+func (s *MumbleSender) Send(message string) {
+	if targetUser := dj.client.Users.Find(s.user.Name); targetUser != nil {
+		targetUser.Send(message)
+	}
+}
+
+// InChannel checks if the Mumble user is in the same channel as MumbleDJ.
+func (s *MumbleSender) InChannel() bool {
+	return dj.IsInChannel(s.user)
+}
+
+// commandMutex ensures that commands from chat and from the HTTP API are performed one at a time.
+var commandMutex sync.Mutex
+
 // parseCommand views incoming chat messages and determines if there is a valid command within them.
 // If a command exists, the arguments (if any) will be parsed and sent to the appropriate helper
 // function to perform the command's task.
-func parseCommand(user *gumble.User, username, command string) {
+func parseCommand(user CommandSender, username, command string) {
 	var com, argument string
 	split := strings.Split(command, "\n")
 	splitString := split[0]
@@ -32,6 +69,18 @@ func parseCommand(user *gumble.User, username, command string) {
 		com = command
 		argument = ""
 	}
+	logger.WithFields(logrus.Fields{
+		"user":    username,
+		"command": com,
+	}).Debug("Received a command.")
+
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
+	if !user.InChannel() && !dj.IsRemoteCommand(com) {
+		dj.SendPrivateMessage(user, dj.messages.Render(REMOTE_COMMAND_NOT_ALLOWED_MSG, MessageData{}))
+		return
+	}
 
 	switch com {
 	// Add command
@@ -39,130 +88,161 @@ func parseCommand(user *gumble.User, username, command string) {
 		if dj.HasPermission(username, dj.conf.Permissions.AdminAdd) {
 			add(user, username, argument)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Skip command
 	case dj.conf.Aliases.SkipAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminSkip) {
 			skip(user, username, false, false)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Skip playlist command
 	case dj.conf.Aliases.SkipPlaylistAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminAddPlaylists) {
 			skip(user, username, false, true)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Forceskip command
 	case dj.conf.Aliases.AdminSkipAlias:
 		if dj.HasPermission(username, true) {
 			skip(user, username, true, false)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Playlist forceskip command
 	case dj.conf.Aliases.AdminSkipPlaylistAlias:
 		if dj.HasPermission(username, true) {
 			skip(user, username, true, true)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Help command
 	case dj.conf.Aliases.HelpAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminHelp) {
 			help(user)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Volume command
 	case dj.conf.Aliases.VolumeAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminVolume) {
 			volume(user, username, argument)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
+		}
+	// Pause command
+	case dj.conf.Aliases.PauseAlias:
+		if dj.HasPermission(username, dj.conf.Permissions.AdminPause) {
+			pause(user, username)
+		} else {
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
+		}
+	// Resume command
+	case dj.conf.Aliases.ResumeAlias:
+		if dj.HasPermission(username, dj.conf.Permissions.AdminPause) {
+			resume(user, username)
+		} else {
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Move command
 	case dj.conf.Aliases.MoveAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminMove) {
 			move(user, argument)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Reload command
 	case dj.conf.Aliases.ReloadAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminReload) {
 			reload(user)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Reset command
 	case dj.conf.Aliases.ResetAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminReset) {
-			reset(username)
+			reset(user, username)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Numsongs command
 	case dj.conf.Aliases.NumSongsAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminNumSongs) {
-			numSongs()
+			numSongs(user)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Nextsong command
 	case dj.conf.Aliases.NextSongAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminNextSong) {
 			nextSong(user)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Currentsong command
 	case dj.conf.Aliases.CurrentSongAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminCurrentSong) {
 			currentSong(user)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Setcomment command
 	case dj.conf.Aliases.SetCommentAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminSetComment) {
 			setComment(user, argument)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Numcached command
 	case dj.conf.Aliases.NumCachedAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminNumCached) {
 			numCached(user)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Cachesize command
 	case dj.conf.Aliases.CacheSizeAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminCacheSize) {
 			cacheSize(user)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
+		}
+	// Cache command
+	case dj.conf.Aliases.CacheAlias:
+		if dj.HasPermission(username, dj.conf.Permissions.AdminCache) {
+			cache(user, argument)
+		} else {
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
+		}
+	// Quota command
+	case dj.conf.Aliases.QuotaAlias:
+		if dj.HasPermission(username, dj.conf.Permissions.AdminQuota) {
+			quota(user)
+		} else {
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	// Kill command
 	case dj.conf.Aliases.KillAlias:
 		if dj.HasPermission(username, dj.conf.Permissions.AdminKill) {
-			kill()
+			kill(user)
 		} else {
-			dj.SendPrivateMessage(user, NO_PERMISSION_MSG)
+			dj.SendPrivateMessage(user, dj.messages.Render(NO_PERMISSION_MSG, MessageData{}))
 		}
 	default:
-		dj.SendPrivateMessage(user, COMMAND_DOESNT_EXIST_MSG)
+		dj.SendPrivateMessage(user, dj.messages.Render(COMMAND_DOESNT_EXIST_MSG, MessageData{}))
 	}
 }
 
+// youtubePlaylistPattern matches the URL of a YouTube playlist, capturing its ID.
+const youtubePlaylistPattern = `https?:\/\/www\.youtube\.com\/playlist\?list=([\w-]+)`
+
 // add performs !add functionality. Checks input URL for YouTube format, and adds
 // the URL to the queue if the format matches.
-func add(user *gumble.User, username, url string) {
+func add(user CommandSender, username, url string) {
 	if url == "" {
-		dj.SendPrivateMessage(user, NO_ARGUMENT_MSG)
+		dj.SendPrivateMessage(user, dj.messages.Render(NO_ARGUMENT_MSG, MessageData{}))
 	} else {
 		youtubePatterns := []string{
 			`https?:\/\/www\.youtube\.com\/watch\?v=([\w-]+)(\&t=\d*m?\d*s?)?`,
@@ -191,272 +271,539 @@ func add(user *gumble.User, username, url string) {
 
 		if matchFound {
 			if newSong, err := NewYouTubeSong(username, shortURL, startOffset, nil); err == nil {
-				dj.client.Self.Channel.Send(fmt.Sprintf(SONG_ADDED_HTML, username, newSong.title), false)
+				dj.events.OnSongQueued(&SongQueuedEvent{Sender: user, Song: newSong})
 				if dj.queue.Len() == 1 && !dj.audioStream.IsPlaying() {
-					if err := dj.queue.CurrentSong().Download(); err == nil {
-						dj.queue.CurrentSong().Play()
-					} else {
-						dj.SendPrivateMessage(user, AUDIO_FAIL_MSG)
-						dj.queue.CurrentSong().Delete()
-						dj.queue.OnSongFinished()
-					}
+					dj.queue.PrepareAndPlayNextSong(user)
 				}
-			} else if fmt.Sprint(err) == "Song exceeds the maximum allowed duration." {
-				dj.SendPrivateMessage(user, VIDEO_TOO_LONG_MSG)
-			} else if fmt.Sprint(err) == "Invalid API key supplied." {
-				dj.SendPrivateMessage(user, INVALID_API_KEY)
 			} else {
-				dj.SendPrivateMessage(user, INVALID_YOUTUBE_ID_MSG)
+				dj.SendPrivateMessage(user, dj.messages.Render(youtubeErrorMessage(err), MessageData{}))
 			}
 		} else {
 			// Check to see if we have a playlist URL instead.
-			youtubePlaylistPattern := `https?:\/\/www\.youtube\.com\/playlist\?list=([\w-]+)`
 			if re, err := regexp.Compile(youtubePlaylistPattern); err == nil {
 				if re.MatchString(url) {
-					if dj.HasPermission(username, dj.conf.Permissions.AdminAddPlaylists) {
-						shortURL = re.FindStringSubmatch(url)[1]
-						oldLength := dj.queue.Len()
-						if newPlaylist, err := NewYouTubePlaylist(username, shortURL); err == nil {
-							dj.client.Self.Channel.Send(fmt.Sprintf(PLAYLIST_ADDED_HTML, username, newPlaylist.title), false)
-							if oldLength == 0 && dj.queue.Len() != 0 && !dj.audioStream.IsPlaying() {
-								if err := dj.queue.CurrentSong().Download(); err == nil {
-									dj.queue.CurrentSong().Play()
-								} else {
-									dj.SendPrivateMessage(user, AUDIO_FAIL_MSG)
-									dj.queue.CurrentSong().Delete()
-									dj.queue.OnSongFinished()
-								}
-							}
-						} else {
-							dj.SendPrivateMessage(user, INVALID_YOUTUBE_ID_MSG)
-						}
+					if dj.SenderHasPermission(user, dj.conf.Permissions.AdminAddPlaylists) {
+						dj.SendPrivateMessage(user, dj.messages.Render(PLAYLIST_LOOKUP_MSG, MessageData{}))
+						go addPlaylist(user, username, re.FindStringSubmatch(url)[1])
 					} else {
-						dj.SendPrivateMessage(user, NO_PLAYLIST_PERMISSION_MSG)
+						dj.SendPrivateMessage(user, dj.messages.Render(NO_PLAYLIST_PERMISSION_MSG, MessageData{}))
 					}
 				} else {
-					dj.SendPrivateMessage(user, INVALID_URL_MSG)
+					dj.SendPrivateMessage(user, dj.messages.Render(INVALID_URL_MSG, MessageData{}))
 				}
 			}
 		}
 	}
 }
 
+// addPlaylist looks up the YouTube playlist with the given ID and adds its songs to the queue.
+// Looking a playlist up with the downloader can take minutes, so it runs in the background, and
+// commandMutex is only taken once the songs are known. Nothing is added if MumbleDJ has
+// disconnected or begun shutting down in the meantime.
+func addPlaylist(user CommandSender, username, id string) {
+	playlist, songs, err := FetchYouTubePlaylist(username, id)
+	if err != nil {
+		sendLater(user, dj.messages.Render(youtubeErrorMessage(err), MessageData{}))
+		return
+	}
+
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+	if !dj.connected || dj.ShuttingDown() {
+		return
+	}
+	oldLength := dj.queue.Len()
+	for _, song := range songs {
+		dj.queue.AddSong(song)
+	}
+	dj.events.OnPlaylistQueued(&PlaylistQueuedEvent{Sender: user, Playlist: playlist, Songs: dj.queue.Len() - oldLength})
+	if oldLength == 0 && dj.queue.Len() != 0 && !dj.audioStream.IsPlaying() {
+		dj.queue.PrepareAndPlayNextSong(user)
+	}
+}
+
+// youtubeErrorMessage returns the message shown to users when a YouTube video or playlist could
+// not be added because of err.
+func youtubeErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrSongTooLong):
+		return VIDEO_TOO_LONG_MSG
+	case errors.Is(err, ErrInvalidAPIKey):
+		return INVALID_API_KEY
+	case errors.Is(err, ErrForbidden):
+		return YOUTUBE_FORBIDDEN_MSG
+	case errors.Is(err, ErrQuotaExceeded):
+		return YOUTUBE_QUOTA_EXCEEDED_MSG
+	case errors.Is(err, ErrNetwork):
+		return YOUTUBE_UNAVAILABLE_MSG
+	}
+	return INVALID_YOUTUBE_ID_MSG
+}
+
 // skip performs !skip functionality. Adds a skip to the skippers slice for the current song, and then
 // evaluates if a skip should be performed. Both skip and forceskip are implemented here.
-func skip(user *gumble.User, username string, admin, playlistSkip bool) {
-	if dj.audioStream.IsPlaying() {
+func skip(user CommandSender, username string, admin, playlistSkip bool) {
+	if dj.HasCurrentSong() {
 		if playlistSkip {
-			if dj.queue.CurrentSong().Playlist() != nil {
-				if err := dj.queue.CurrentSong().Playlist().AddSkip(username); err == nil {
-					submitterSkipped := false
-					if admin {
-						dj.client.Self.Channel.Send(ADMIN_PLAYLIST_SKIP_MSG, false)
-					} else if dj.queue.CurrentSong().Submitter() == username {
-						dj.client.Self.Channel.Send(fmt.Sprintf(PLAYLIST_SUBMITTER_SKIP_HTML, username), false)
-						submitterSkipped = true
-					} else {
-						dj.client.Self.Channel.Send(fmt.Sprintf(PLAYLIST_SKIP_ADDED_HTML, username), false)
-					}
-					if submitterSkipped || dj.queue.CurrentSong().Playlist().SkipReached(len(dj.client.Self.Channel.Users)) || admin {
-						id := dj.queue.CurrentSong().Playlist().ID()
-						dj.queue.CurrentSong().Playlist().DeleteSkippers()
-						for i := 0; i < len(dj.queue.queue); i++ {
-							if dj.queue.queue[i].Playlist() != nil {
-								if dj.queue.queue[i].Playlist().ID() == id {
-									dj.queue.queue = append(dj.queue.queue[:i], dj.queue.queue[i+1:]...)
-									i--
-								}
-							}
-						}
+			if playlist := dj.queue.CurrentSong().Playlist(); playlist != nil {
+				if err := playlist.AddSkip(username); err == nil {
+					submitterSkipped := !admin && dj.queue.CurrentSong().Submitter() == username
+					if submitterSkipped || playlist.SkipReached(len(dj.client.Self.Channel.Users)) || admin {
+						dj.events.OnPlaylistSkipped(&PlaylistSkippedEvent{
+							Sender:      user,
+							Playlist:    playlist,
+							Forced:      admin,
+							BySubmitter: submitterSkipped,
+						})
+						id := playlist.ID()
+						playlist.DeleteSkippers()
+						dj.queue.RemovePlaylist(id)
 						if dj.queue.Len() != 0 {
 							// Set dontSkip to true to avoid audioStream.Stop() callback skipping the new first song.
 							dj.queue.CurrentSong().SetDontSkip(true)
 						}
-						if !(submitterSkipped || admin) {
-							dj.client.Self.Channel.Send(PLAYLIST_SKIPPED_HTML, false)
-						}
-						if err := dj.audioStream.Stop(); err != nil {
-							panic(errors.New("An error occurred while stopping the current song."))
+						if err := dj.StopSong(); err != nil {
+							commandError(user, "skipplaylist", "An error occurred while stopping the current song.", err)
 						}
+					} else {
+						dj.events.OnSkipVoted(&SkipVotedEvent{Sender: user, Song: dj.queue.CurrentSong(), Playlist: true})
 					}
 				}
 			} else {
-				dj.SendPrivateMessage(user, NO_PLAYLIST_PLAYING_MSG)
+				dj.SendPrivateMessage(user, dj.messages.Render(NO_PLAYLIST_PLAYING_MSG, MessageData{}))
 			}
 		} else {
 			if err := dj.queue.CurrentSong().AddSkip(username); err == nil {
-				submitterSkipped := false
-				if admin {
-					dj.client.Self.Channel.Send(ADMIN_SONG_SKIP_MSG, false)
-				} else if dj.queue.CurrentSong().Submitter() == username {
-					dj.client.Self.Channel.Send(fmt.Sprintf(SUBMITTER_SKIP_HTML, username), false)
-					submitterSkipped = true
-				} else {
-					dj.client.Self.Channel.Send(fmt.Sprintf(SKIP_ADDED_HTML, username), false)
-				}
+				submitterSkipped := !admin && dj.queue.CurrentSong().Submitter() == username
 				if submitterSkipped || dj.queue.CurrentSong().SkipReached(len(dj.client.Self.Channel.Users)) || admin {
-					if !(submitterSkipped || admin) {
-						dj.client.Self.Channel.Send(SONG_SKIPPED_HTML, false)
-					}
-					if err := dj.audioStream.Stop(); err != nil {
-						panic(errors.New("An error occurred while stopping the current song."))
+					dj.events.OnSongSkipped(&SongSkippedEvent{
+						Sender:      user,
+						Song:        dj.queue.CurrentSong(),
+						Forced:      admin,
+						BySubmitter: submitterSkipped,
+					})
+					if err := dj.StopSong(); err != nil {
+						commandError(user, "skip", "An error occurred while stopping the current song.", err)
 					}
+				} else {
+					dj.events.OnSkipVoted(&SkipVotedEvent{Sender: user, Song: dj.queue.CurrentSong()})
 				}
 			}
 		}
 	} else {
-		dj.SendPrivateMessage(user, NO_MUSIC_PLAYING_MSG)
+		dj.SendPrivateMessage(user, dj.messages.Render(NO_MUSIC_PLAYING_MSG, MessageData{}))
 	}
 }
 
 // help performs !help functionality. Displays a list of valid commands.
-func help(user *gumble.User) {
-	dj.SendPrivateMessage(user, HELP_HTML)
+func help(user CommandSender) {
+	dj.SendPrivateMessage(user, dj.messages.Render(HELP_HTML, MessageData{}))
 }
 
 // volume performs !volume functionality. Checks input value against LowestVolume and HighestVolume from
 // config to determine if the volume should be applied. If in the correct range, the new volume
 // is applied and is immediately in effect.
-func volume(user *gumble.User, username, value string) {
+func volume(user CommandSender, username, value string) {
 	if value == "" {
-		dj.client.Self.Channel.Send(fmt.Sprintf(CUR_VOLUME_HTML, dj.audioStream.Volume), false)
+		dj.SendChannelMessage(user, dj.messages.Render(CUR_VOLUME_HTML, MessageData{Volume: dj.audioStream.Volume}))
 	} else {
+		volumeRange := MessageData{
+			LowestVolume:  dj.conf.Volume.LowestVolume,
+			HighestVolume: dj.conf.Volume.HighestVolume,
+		}
 		if parsedVolume, err := strconv.ParseFloat(value, 32); err == nil {
 			newVolume := float32(parsedVolume)
 			if newVolume >= dj.conf.Volume.LowestVolume && newVolume <= dj.conf.Volume.HighestVolume {
 				dj.audioStream.Volume = newVolume
-				dj.client.Self.Channel.Send(fmt.Sprintf(VOLUME_SUCCESS_HTML, username, dj.audioStream.Volume), false)
+				dj.volumeSet = true
+				dj.events.OnVolumeChanged(&VolumeChangedEvent{Sender: user, Volume: dj.audioStream.Volume})
 			} else {
-				dj.SendPrivateMessage(user, fmt.Sprintf(NOT_IN_VOLUME_RANGE_MSG, dj.conf.Volume.LowestVolume, dj.conf.Volume.HighestVolume))
+				dj.SendPrivateMessage(user, dj.messages.Render(NOT_IN_VOLUME_RANGE_MSG, volumeRange))
 			}
 		} else {
-			dj.SendPrivateMessage(user, fmt.Sprintf(NOT_IN_VOLUME_RANGE_MSG, dj.conf.Volume.LowestVolume, dj.conf.Volume.HighestVolume))
+			dj.SendPrivateMessage(user, dj.messages.Render(NOT_IN_VOLUME_RANGE_MSG, volumeRange))
 		}
 	}
 }
 
+// pause performs !pause functionality. Pauses the song that is currently playing so that it may
+// later be resumed from the same position with !resume.
+func pause(user CommandSender, username string) {
+	if dj.paused {
+		dj.SendPrivateMessage(user, dj.messages.Render(ALREADY_PAUSED_MSG, MessageData{}))
+	} else if !dj.audioStream.IsPlaying() {
+		dj.SendPrivateMessage(user, dj.messages.Render(NO_MUSIC_PLAYING_MSG, MessageData{}))
+	} else if err := dj.Pause(); err != nil {
+		commandError(user, "pause", "An error occurred while pausing the current song.", err)
+	} else {
+		dj.events.OnPlaybackPaused(&PlaybackPausedEvent{Sender: user, Song: dj.queue.CurrentSong()})
+	}
+}
+
+// resume performs !resume functionality. Resumes the paused song from the position at which it
+// was paused.
+func resume(user CommandSender, username string) {
+	if !dj.paused {
+		dj.SendPrivateMessage(user, dj.messages.Render(NOT_PAUSED_MSG, MessageData{}))
+	} else if err := dj.Resume(); err != nil {
+		commandError(user, "resume", "An error occurred while resuming the current song.", err)
+	} else {
+		dj.events.OnPlaybackResumed(&PlaybackResumedEvent{Sender: user, Song: dj.queue.CurrentSong()})
+	}
+}
+
 // move performs !move functionality. Determines if the supplied channel is valid and moves the bot
 // to the channel if it is.
-func move(user *gumble.User, channel string) {
+func move(user CommandSender, channel string) {
 	if channel == "" {
-		dj.SendPrivateMessage(user, NO_ARGUMENT_MSG)
+		dj.SendPrivateMessage(user, dj.messages.Render(NO_ARGUMENT_MSG, MessageData{}))
 	} else {
 		if channels := strings.Split(channel, "/"); dj.client.Channels.Find(channels...) != nil {
 			dj.client.Self.Move(dj.client.Channels.Find(channels...))
 		} else {
-			dj.SendPrivateMessage(user, CHANNEL_DOES_NOT_EXIST_MSG+" "+channel)
+			dj.SendPrivateMessage(user, dj.messages.Render(CHANNEL_DOES_NOT_EXIST_MSG, MessageData{Channel: channel}))
 		}
 	}
 }
 
-// reload performs !reload functionality. Tells command submitter if the reload completed successfully.
-func reload(user *gumble.User) {
-	if err := loadConfiguration(); err == nil {
-		dj.SendPrivateMessage(user, CONFIG_RELOAD_SUCCESS_MSG)
+// reload performs !reload functionality. Tells command submitter which settings were changed by the
+// reload, or which errors prevented the reload from completing.
+func reload(user CommandSender) {
+	if changes, err := reloadConfiguration(); err != nil {
+		dj.SendPrivateMessage(user, dj.messages.Render(CONFIG_RELOAD_FAILED_MSG, MessageData{Errors: configErrorLines(err)}))
+	} else if len(changes) == 0 {
+		dj.SendPrivateMessage(user, dj.messages.Render(CONFIG_RELOAD_UNCHANGED_MSG, MessageData{}))
+	} else {
+		dj.SendPrivateMessage(user, dj.messages.Render(CONFIG_RELOAD_SUCCESS_MSG, MessageData{Changes: changes}))
 	}
 }
 
 // reset performs !reset functionality. Clears the song queue, stops playing audio, and deletes all
-// remaining songs in the ~/.mumbledj/songs directory.
-func reset(username string) {
-	dj.queue.queue = dj.queue.queue[:0]
-	if dj.audioStream.IsPlaying() {
-		if err := dj.audioStream.Stop(); err != nil {
-			panic(err)
+// remaining songs in the songs directory unless the cache is enabled, in which case
+// the songs stay cached. The reset is only announced if all of this succeeds.
+func reset(user CommandSender, username string) {
+	dj.queue.Clear()
+	if dj.HasCurrentSong() {
+		if err := dj.StopSong(); err != nil {
+			commandError(user, "reset", "An error occurred while stopping the current song.", err)
+			return
 		}
 	}
-	if err := deleteSongs(); err == nil {
-		dj.client.Self.Channel.Send(fmt.Sprintf(QUEUE_RESET_HTML, username), false)
-	} else {
-		panic(err)
+	if !dj.conf.Cache.Enabled {
+		if err := deleteSongs(); err != nil {
+			commandError(user, "reset", "An error occurred while deleting the audio files.", err)
+			return
+		}
 	}
+	dj.events.OnQueueReset(&QueueResetEvent{Sender: user})
 }
 
 // numSongs performs !numsongs functionality. Uses the SongQueue traversal function to traverse the
 // queue with a function call that increments a counter. Once finished, the bot outputs
 // the number of songs in the queue to chat.
-func numSongs() {
+func numSongs(user CommandSender) {
 	songCount := 0
 	dj.queue.Traverse(func(i int, song Song) {
 		songCount++
 	})
-	dj.client.Self.Channel.Send(fmt.Sprintf(NUM_SONGS_HTML, songCount), false)
+	dj.SendChannelMessage(user, dj.messages.Render(NUM_SONGS_HTML, MessageData{Count: songCount}))
 }
 
 // nextSong performs !nextsong functionality. Uses the SongQueue PeekNext function to peek at the next
 // item if it exists. The user will then be sent a message containing the title and submitter
 // of the next item if it exists.
-func nextSong(user *gumble.User) {
+func nextSong(user CommandSender) {
 	if song, err := dj.queue.PeekNext(); err != nil {
-		dj.SendPrivateMessage(user, NO_SONG_NEXT_MSG)
+		dj.SendPrivateMessage(user, dj.messages.Render(NO_SONG_NEXT_MSG, MessageData{}))
 	} else {
-		dj.SendPrivateMessage(user, fmt.Sprintf(NEXT_SONG_HTML, song.Title(), song.Submitter()))
+		dj.SendPrivateMessage(user, dj.messages.Render(NEXT_SONG_HTML, SongMessageData(song)))
 	}
 }
 
 // currentSong performs !currentsong functionality. Sends the user who submitted the currentsong command
 // information about the song currently playing.
-func currentSong(user *gumble.User) {
-	if dj.audioStream.IsPlaying() {
+func currentSong(user CommandSender) {
+	if dj.HasCurrentSong() {
 		if dj.queue.CurrentSong().Playlist() == nil {
-			dj.SendPrivateMessage(user, fmt.Sprintf(CURRENT_SONG_HTML, dj.queue.CurrentSong().Title(), dj.queue.CurrentSong().Submitter()))
+			dj.SendPrivateMessage(user, dj.messages.Render(CURRENT_SONG_HTML, SongMessageData(dj.queue.CurrentSong())))
 		} else {
-			dj.SendPrivateMessage(user, fmt.Sprintf(CURRENT_SONG_PLAYLIST_HTML, dj.queue.CurrentSong().Title(),
-				dj.queue.CurrentSong().Submitter(), dj.queue.CurrentSong().Playlist().Title()))
+			dj.SendPrivateMessage(user, dj.messages.Render(CURRENT_SONG_PLAYLIST_HTML, SongMessageData(dj.queue.CurrentSong())))
 		}
 	} else {
-		dj.SendPrivateMessage(user, NO_MUSIC_PLAYING_MSG)
+		dj.SendPrivateMessage(user, dj.messages.Render(NO_MUSIC_PLAYING_MSG, MessageData{}))
 	}
 }
 
 // setComment performs !setcomment functionality. Sets the bot's comment to whatever text is supplied in the argument.
-func setComment(user *gumble.User, comment string) {
+func setComment(user CommandSender, comment string) {
 	dj.client.Self.SetComment(comment)
-	dj.SendPrivateMessage(user, COMMENT_UPDATED_MSG)
+	dj.SendPrivateMessage(user, dj.messages.Render(COMMENT_UPDATED_MSG, MessageData{}))
 }
 
-// numCached performs !numcached functionality. Displays the number of songs currently cached on disk at ~/.mumbledj/songs.
-func numCached(user *gumble.User) {
+// numCached performs !numcached functionality. Displays the number of songs currently cached on disk in the songs directory.
+func numCached(user CommandSender) {
 	if dj.conf.Cache.Enabled {
 		dj.cache.Update()
-		dj.SendPrivateMessage(user, fmt.Sprintf(NUM_CACHED_MSG, dj.cache.NumSongs))
+		dj.SendPrivateMessage(user, dj.messages.Render(NUM_CACHED_MSG, MessageData{Count: dj.cache.NumSongs}))
 	} else {
-		dj.SendPrivateMessage(user, CACHE_NOT_ENABLED_MSG)
+		dj.SendPrivateMessage(user, dj.messages.Render(CACHE_NOT_ENABLED_MSG, MessageData{}))
 	}
 }
 
 // cacheSize performs !cachesize functionality. Displays the total file size of the cached audio files.
-func cacheSize(user *gumble.User) {
+func cacheSize(user CommandSender) {
 	if dj.conf.Cache.Enabled {
 		dj.cache.Update()
-		dj.SendPrivateMessage(user, fmt.Sprintf(CACHE_SIZE_MSG, float64(dj.cache.TotalFileSize/1048576)))
+		dj.SendPrivateMessage(user, dj.messages.Render(CACHE_SIZE_MSG, MessageData{Size: float64(dj.cache.TotalFileSize / 1048576)}))
 	} else {
-		dj.SendPrivateMessage(user, CACHE_NOT_ENABLED_MSG)
+		dj.SendPrivateMessage(user, dj.messages.Render(CACHE_NOT_ENABLED_MSG, MessageData{}))
 	}
 }
 
-// kill performs !kill functionality. First cleans the ~/.mumbledj/songs directory to get rid of any
-// excess m4a files. The bot then safely disconnects from the server.
-func kill() {
-	if err := deleteSongs(); err != nil {
-		panic(err)
+// cacheListPageSize is the number of songs shown on each page of !cache list.
+const cacheListPageSize = 10
+
+// cache performs !cache functionality. "list [page]" lists the cached songs, "purge <id|all|
+// older-than <age>>" removes songs from the cache, "prewarm <playlist url>" downloads a playlist
+// into the cache without queueing it, "pin <id>" protects a cached song from being removed when
+// it expires or the cache is full, and "unpin <id>" removes that protection.
+func cache(user CommandSender, argument string) {
+	if !dj.conf.Cache.Enabled {
+		dj.SendPrivateMessage(user, dj.messages.Render(CACHE_NOT_ENABLED_MSG, MessageData{}))
+		return
 	}
-	if err := dj.client.Disconnect(); err == nil {
-		fmt.Println("Kill successful. Goodbye!")
-		os.Exit(0)
-	} else {
-		panic(errors.New("An error occurred while disconnecting from the server."))
+	args := strings.Fields(argument)
+	if len(args) == 0 {
+		dj.SendPrivateMessage(user, dj.messages.Render(CACHE_USAGE_MSG, MessageData{}))
+		return
+	}
+	switch args[0] {
+	case "list":
+		page := 1
+		if len(args) > 1 {
+			if parsed, err := strconv.Atoi(args[1]); err == nil && parsed > 0 {
+				page = parsed
+			}
+		}
+		cacheList(user, page)
+	case "purge":
+		if len(args) < 2 {
+			dj.SendPrivateMessage(user, dj.messages.Render(CACHE_USAGE_MSG, MessageData{}))
+		} else {
+			cachePurge(user, args[1:])
+		}
+	case "prewarm":
+		if len(args) != 2 {
+			dj.SendPrivateMessage(user, dj.messages.Render(CACHE_USAGE_MSG, MessageData{}))
+		} else if match := regexp.MustCompile(youtubePlaylistPattern).FindStringSubmatch(args[1]); match == nil {
+			dj.SendPrivateMessage(user, dj.messages.Render(INVALID_URL_MSG, MessageData{}))
+		} else {
+			dj.SendPrivateMessage(user, dj.messages.Render(CACHE_PREWARM_REQUESTED_MSG, MessageData{}))
+			go cachePrewarm(user, match[1])
+		}
+	case "pin", "unpin":
+		if len(args) != 2 {
+			dj.SendPrivateMessage(user, dj.messages.Render(CACHE_USAGE_MSG, MessageData{}))
+		} else if entry, err := dj.cache.Pin(args[1], args[0] == "pin"); err != nil {
+			dj.SendPrivateMessage(user, dj.messages.Render(NOT_CACHED_MSG, MessageData{ID: args[1]}))
+		} else if entry.Pinned {
+			dj.SendPrivateMessage(user, dj.messages.Render(CACHE_PINNED_MSG, MessageData{Title: entry.Title, ID: entry.ID}))
+		} else {
+			dj.SendPrivateMessage(user, dj.messages.Render(CACHE_UNPINNED_MSG, MessageData{Title: entry.Title, ID: entry.ID}))
+		}
+	default:
+		dj.SendPrivateMessage(user, dj.messages.Render(CACHE_USAGE_MSG, MessageData{}))
+	}
+}
+
+// cacheList sends the user a page of the songs in the cache, starting with the most recently
+// used.
+func cacheList(user CommandSender, page int) {
+	entries := dj.cache.Entries()
+	if len(entries) == 0 {
+		dj.SendPrivateMessage(user, dj.messages.Render(CACHE_EMPTY_MSG, MessageData{}))
+		return
+	}
+	pages := (len(entries) + cacheListPageSize - 1) / cacheListPageSize
+	if page > pages {
+		page = pages
+	}
+	data := MessageData{Page: page, Pages: pages, Total: len(entries)}
+	end := page * cacheListPageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+	for _, entry := range entries[(page-1)*cacheListPageSize : end] {
+		data.Cached = append(data.Cached, CachedSongData{
+			ID:        entry.ID,
+			Title:     entry.Title,
+			Size:      float64(entry.Size) / 1048576,
+			Age:       formatAge(time.Since(entry.LastUsed())),
+			PlayCount: entry.PlayCount,
+			Pinned:    entry.Pinned,
+		})
 	}
+	dj.SendPrivateMessage(user, dj.messages.Render(CACHE_LIST_HTML, data))
 }
 
-// deleteSongs deletes songs from ~/.mumbledj/songs.
+// cachePurge removes the song with the given ID, every song ("all") or every song that has not
+// been used for a given time ("older-than 48h") from the cache. Pinned songs are only removed
+// when they are purged by ID, and songs in the queue are never removed.
+func cachePurge(user CommandSender, args []string) {
+	var match func(entry *CacheEntry) bool
+	switch {
+	case args[0] == "all" && len(args) == 1:
+		match = func(entry *CacheEntry) bool {
+			return !entry.Pinned
+		}
+	case args[0] == "older-than" && len(args) == 2:
+		age, err := parseAge(args[1])
+		if err != nil {
+			dj.SendPrivateMessage(user, dj.messages.Render(CACHE_USAGE_MSG, MessageData{}))
+			return
+		}
+		match = func(entry *CacheEntry) bool {
+			return !entry.Pinned && time.Since(entry.LastUsed()) >= age
+		}
+	case len(args) == 1:
+		id := args[0]
+		if !dj.cache.Has(id) {
+			dj.SendPrivateMessage(user, dj.messages.Render(NOT_CACHED_MSG, MessageData{ID: id}))
+			return
+		}
+		match = func(entry *CacheEntry) bool {
+			return entry.ID == id
+		}
+	default:
+		dj.SendPrivateMessage(user, dj.messages.Render(CACHE_USAGE_MSG, MessageData{}))
+		return
+	}
+	removed, size := dj.cache.Purge(match)
+	dj.cache.Update()
+	dj.SendPrivateMessage(user, dj.messages.Render(CACHE_PURGED_MSG, MessageData{Count: removed, Size: float64(size) / 1048576}))
+}
+
+// cachePrewarm downloads the songs in a YouTube playlist into the cache without adding them to
+// the queue, and reports its progress to the user privately. It runs in the background, with the
+// songs downloaded as many at a time as the download manager allows.
+func cachePrewarm(user CommandSender, id string) {
+	playlist, songs, err := FetchYouTubePlaylist(user.Name(), id)
+	if err != nil {
+		sendLater(user, dj.messages.Render(youtubeErrorMessage(err), MessageData{}))
+		return
+	}
+	data := MessageData{Playlist: playlist.Title(), Total: len(songs)}
+	sendLater(user, dj.messages.Render(CACHE_PREWARM_STARTED_MSG, data))
+	var (
+		mutex    sync.Mutex
+		finished sync.WaitGroup
+		done     int
+	)
+	for _, song := range songs {
+		finished.Add(1)
+		go func(song *YouTubeSong) {
+			defer finished.Done()
+			if !dj.conf.Cache.Enabled || dj.ShuttingDown() {
+				return
+			}
+			err := song.Download()
+			mutex.Lock()
+			if err != nil {
+				logger.WithFields(songFields(song)).WithError(err).Warn("Could not download a song while prewarming the cache.")
+				data.Failed++
+			} else {
+				data.Count++
+			}
+			done++
+			progress := data
+			progress.Count = done
+			mutex.Unlock()
+			if progress.Count%5 == 0 && progress.Count < len(songs) {
+				sendLater(user, dj.messages.Render(CACHE_PREWARM_PROGRESS_MSG, progress))
+			}
+		}(song)
+	}
+	finished.Wait()
+	commandMutex.Lock()
+	dj.cache.Update()
+	commandMutex.Unlock()
+	sendLater(user, dj.messages.Render(CACHE_PREWARM_FINISHED_MSG, data))
+}
+
+// sendLater sends a message to user from a command that carries on in the background. Mumble users
+// are sent the message while commandMutex is held, as they are by commands. The response to a
+// command sent through the HTTP API or the control socket has already been sent by then, so for
+// those the message is logged instead.
+func sendLater(user CommandSender, message string) {
+	if _, ok := user.(*MumbleSender); !ok {
+		logger.WithField("user", user.Name()).Info(PlainText(message))
+		return
+	}
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+	dj.SendPrivateMessage(user, message)
+}
+
+// formatAge formats how long ago a cached song was last used, in minutes, hours or days.
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	}
+}
+
+// parseAge parses an age given to !cache purge older-than, such as "48h", "90m" or "7d".
+func parseAge(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err != nil || days < 0 {
+			return 0, errors.New("The age is not valid.")
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, errors.New("The age is not valid.")
+	}
+	return age, nil
+}
+
+// quota performs !quota functionality. Displays an estimate of the YouTube Data API quota used
+// today, along with the number of lookups answered from the metadata cache instead.
+func quota(user CommandSender) {
+	usage := dj.metadata.Quota()
+	dj.SendPrivateMessage(user, dj.messages.Render(QUOTA_MSG, MessageData{
+		Count:     usage.Units,
+		Total:     dj.conf.YouTube.DailyQuota,
+		Requests:  usage.Requests,
+		CacheHits: usage.CacheHits,
+	}))
+}
+
+// kill performs !kill functionality. MumbleDJ shuts down the same way it does when it receives
+// SIGTERM, saving the queue and waiting for downloads, and also deletes the downloaded songs unless
+// the cache is enabled. Shutting down takes commandMutex, so it is started in its own goroutine.
+func kill(user CommandSender) {
+	logger.WithField("user", user.Name()).Info("Shutting down.")
+	go ShutdownAndExit(dj.conf.Shutdown.DeleteSongs || !dj.conf.Cache.Enabled)
+}
+
+// deleteSongs deletes every song from the songs directory. The directory itself is kept, as it
+// may be the mount point of a separate volume.
 func deleteSongs() error {
-	songsDir := fmt.Sprintf("%s/.mumbledj/songs", dj.homeDir)
-	if err := os.RemoveAll(songsDir); err != nil {
+	songs, err := ioutil.ReadDir(dj.paths.Songs)
+	if err != nil && !os.IsNotExist(err) {
 		return errors.New("An error occurred while deleting the audio files.")
 	}
-	if err := os.Mkdir(songsDir, 0777); err != nil {
-		return errors.New("An error occurred while recreating the songs directory.")
+	for _, song := range songs {
+		if err := os.RemoveAll(songPath(song.Name())); err != nil {
+			return errors.New("An error occurred while deleting the audio files.")
+		}
 	}
+	dj.cache.Clear()
 	return nil
 }
@@ -0,0 +1,241 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * configoverrides.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Configuration values are applied in the following order, with later sources taking
+// precedence over earlier ones:
+//
+//   1. Built-in defaults (see defaultConfiguration).
+//   2. The configuration file (mumbledj.gcfg in the configuration directory, see paths.go, or
+//      the path given by -config).
+//   3. Environment variables named MUMBLEDJ_<SECTION>_<VARIABLE>, e.g. MUMBLEDJ_VOLUME_DEFAULTVOLUME.
+//   4. Command-line flags named -<section>.<variable>, e.g. -volume.defaultvolume.
+
+// configField is a single variable of DjConfig, such as General.SkipRatio.
+type configField struct {
+	Section string
+	Name    string
+	Value   reflect.Value
+}
+
+// Key returns the name of the field in the form Section.Name.
+func (f configField) Key() string {
+	return f.Section + "." + f.Name
+}
+
+// EnvName returns the name of the environment variable that overrides the field.
+func (f configField) EnvName() string {
+	return strings.ToUpper("MUMBLEDJ_" + f.Section + "_" + f.Name)
+}
+
+// FlagName returns the name of the command-line flag that overrides the field.
+func (f configField) FlagName() string {
+	return strings.ToLower(f.Section + "." + f.Name)
+}
+
+// configFields returns every variable of conf in the order in which they are declared.
+func configFields(conf *DjConfig) []configField {
+	var fields []configField
+	sections := reflect.ValueOf(conf).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.NumField(); j++ {
+			fields = append(fields, configField{
+				Section: sections.Type().Field(i).Name,
+				Name:    section.Type().Field(j).Name,
+				Value:   section.Field(j),
+			})
+		}
+	}
+	return fields
+}
+
+// setConfigValue parses values according to the type of the field and stores the result.
+// Only list fields accept more than one value.
+func setConfigValue(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice {
+		list := reflect.MakeSlice(field.Type(), 0, len(values))
+		for _, value := range values {
+			list = reflect.Append(list, reflect.ValueOf(value))
+		}
+		field.Set(list)
+		return nil
+	}
+
+	value := values[len(values)-1]
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid boolean.", value)
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid integer.", value)
+		}
+		field.SetInt(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid number.", value)
+		}
+		field.SetFloat(parsed)
+	default:
+		return errors.New("This variable cannot be overridden.")
+	}
+	return nil
+}
+
+// configFlag is a flag.Value that records the values given for a configuration variable on
+// the command line. The values are applied each time the configuration is loaded.
+type configFlag struct {
+	values []string
+}
+
+// String returns the values given for the flag.
+func (f *configFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+// Set records a value given for the flag.
+func (f *configFlag) Set(value string) error {
+	f.values = append(f.values, value)
+	return nil
+}
+
+// configBoolFlag is a configFlag for boolean variables, allowing -flag to be used in place
+// of -flag=true.
+type configBoolFlag struct {
+	configFlag
+}
+
+// IsBoolFlag marks the flag as a boolean flag for the flag package.
+func (f *configBoolFlag) IsBoolFlag() bool {
+	return true
+}
+
+// configFlags maps Section.Name keys to their command-line flags.
+var configFlags = make(map[string]flag.Value)
+
+// legacyFlags maps the command-line flags that predate the [Connection] section to the
+// variables they override.
+var legacyFlags = map[string]string{
+	"server":       "Connection.Server",
+	"port":         "Connection.Port",
+	"username":     "Connection.Username",
+	"password":     "Connection.Password",
+	"channel":      "Connection.Channel",
+	"cert":         "Connection.Cert",
+	"key":          "Connection.Key",
+	"accesstokens": "Connection.AccessTokens",
+	"insecure":     "Connection.Insecure",
+}
+
+// RegisterConfigFlags defines a command-line flag for every configuration variable, along with
+// the legacy connection flags. It must be called before flag.Parse.
+func RegisterConfigFlags() {
+	defaults := defaultConfiguration()
+	for _, field := range configFields(&defaults) {
+		var value flag.Value
+		if field.Value.Kind() == reflect.Bool {
+			value = &configBoolFlag{}
+		} else {
+			value = &configFlag{}
+		}
+		configFlags[field.Key()] = value
+		flag.Var(value, field.FlagName(), fmt.Sprintf("overrides %s (default %v)", field.Key(), field.Value.Interface()))
+	}
+	for name, key := range legacyFlags {
+		flag.Var(configFlags[key], name, "shorthand for -"+strings.ToLower(key))
+	}
+}
+
+// flagValues returns the values given on the command line for a field, if any.
+func flagValues(field configField) []string {
+	switch value := configFlags[field.Key()].(type) {
+	case *configFlag:
+		return value.values
+	case *configBoolFlag:
+		return value.values
+	}
+	return nil
+}
+
+// envValues returns the values given in the environment for a field, if any. List values
+// are separated by commas.
+func envValues(field configField) []string {
+	value := os.Getenv(field.EnvName())
+	if value == "" && field.Key() == "YouTube.APIKey" {
+		value = os.Getenv("YOUTUBE_API_KEY")
+	}
+	if value == "" {
+		return nil
+	}
+	if field.Value.Kind() == reflect.Slice {
+		return strings.Split(value, ",")
+	}
+	return []string{value}
+}
+
+// configOverride returns a description of the environment variable or command-line flag that
+// overrides a field, or an empty string if the field is not overridden.
+func configOverride(section, variable string) string {
+	field := configField{Section: section, Name: variable}
+	if flagValues(field) != nil {
+		return "command-line flag -" + field.FlagName()
+	}
+	if os.Getenv(field.EnvName()) != "" {
+		return "environment variable " + field.EnvName()
+	}
+	return ""
+}
+
+// applyOverrides applies environment variables and then command-line flags on top of the
+// values read from the configuration file. Unknown MUMBLEDJ_ environment variables are logged so
+// that typos do not go unnoticed, but are otherwise ignored.
+func applyOverrides(conf *DjConfig) ConfigErrors {
+	var errs ConfigErrors
+	knownEnv := map[string]bool{"MUMBLEDJ_CONFIG": true}
+	for _, field := range configFields(conf) {
+		knownEnv[field.EnvName()] = true
+		if values := envValues(field); values != nil {
+			if err := setConfigValue(field.Value, values); err != nil {
+				errs = append(errs, ConfigError{File: "environment variable " + field.EnvName(), Field: field.Key(), Message: err.Error()})
+			}
+		}
+		if values := flagValues(field); values != nil {
+			if err := setConfigValue(field.Value, values); err != nil {
+				errs = append(errs, ConfigError{File: "command-line flag -" + field.FlagName(), Field: field.Key(), Message: err.Error()})
+			}
+		}
+	}
+	for _, variable := range os.Environ() {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if strings.HasPrefix(name, "MUMBLEDJ_") && !knownEnv[name] {
+			logger.WithField("variable", name).Warn("Ignoring unknown configuration environment variable.")
+		}
+	}
+	return errs
+}
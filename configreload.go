@@ -0,0 +1,285 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * configreload.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// secretConfigFields holds the configuration variables whose values must never be shown
+// when describing a configuration change.
+var secretConfigFields = map[string]bool{
+	"Connection.Password": true,
+	"YouTube.APIKey":      true,
+	"HTTP.Tokens":         true,
+	"MPD.Passwords":       true,
+	"Webhooks.URLs":       true,
+	"Webhooks.Secret":     true,
+	"Downloader.Proxy":    true,
+}
+
+// configChange describes a configuration variable whose value changed during a reload.
+type configChange struct {
+	Key      string
+	OldValue string
+	NewValue string
+}
+
+// String returns the change in the form "Section.Name: old -> new".
+func (c configChange) String() string {
+	if secretConfigFields[c.Key] {
+		return c.Key + ": (hidden)"
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.Key, c.OldValue, c.NewValue)
+}
+
+// formatConfigValue formats the value of a configuration variable as it would be written in
+// mumbledj.gcfg.
+func formatConfigValue(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("%q", value.String())
+	case reflect.Slice:
+		items := make([]string, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			items[i] = formatConfigValue(value.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// diffConfiguration returns every configuration variable that differs between two configurations.
+func diffConfiguration(oldConfig, newConfig DjConfig) []configChange {
+	var changes []configChange
+	oldFields := configFields(&oldConfig)
+	newFields := configFields(&newConfig)
+	for i := range oldFields {
+		if !reflect.DeepEqual(oldFields[i].Value.Interface(), newFields[i].Value.Interface()) {
+			changes = append(changes, configChange{
+				Key:      oldFields[i].Key(),
+				OldValue: formatConfigValue(oldFields[i].Value),
+				NewValue: formatConfigValue(newFields[i].Value),
+			})
+		}
+	}
+	return changes
+}
+
+// applyConfiguration replaces the current configuration and applies each changed variable to the
+// running bot. A description of each change is returned. Most variables are read whenever they
+// are needed and so take effect immediately without any further work.
+func applyConfiguration(newConfig DjConfig, messages *Messages) []string {
+	oldConfig := dj.conf
+	dj.conf = newConfig
+	dj.messages = messages
+
+	connected := dj.client != nil && dj.client.Self != nil
+	var report []string
+	for _, change := range diffConfiguration(oldConfig, newConfig) {
+		description := change.String()
+		switch change.Key {
+		case "General.DefaultComment":
+			if connected {
+				dj.client.Self.SetComment(newConfig.General.DefaultComment)
+			}
+		case "General.AutoReload":
+			if newConfig.General.AutoReload {
+				if err := StartConfigWatcher(); err != nil {
+					description += fmt.Sprintf(" (could not watch configuration file: %v)", err)
+				}
+			} else {
+				StopConfigWatcher()
+			}
+		case "Volume.DefaultVolume":
+			// A volume chosen by a user or restored from the saved state is kept, and only clamped
+			// to the new bounds below.
+			if dj.audioStream != nil && !dj.volumeSet {
+				dj.audioStream.Volume = newConfig.Volume.DefaultVolume
+				description += fmt.Sprintf(" (volume set to %.2f)", dj.audioStream.Volume)
+			}
+		case "Cache.Enabled":
+			if newConfig.Cache.Enabled {
+				dj.cache.Load()
+				dj.cache.Update()
+				dj.cache.CheckMaximumDirectorySize()
+				dj.cache.StartExpiry()
+			} else {
+				dj.cache.StopExpiry()
+			}
+		case "Cache.MaximumSize":
+			if newConfig.Cache.Enabled {
+				dj.cache.CheckMaximumDirectorySize()
+			}
+		case "Downloader.Executable":
+			if version, err := CheckDownloader(newConfig); err != nil {
+				description += fmt.Sprintf(" (songs cannot be downloaded: %v)", err)
+			} else {
+				description += fmt.Sprintf(" (version %s)", version)
+			}
+		case "Downloader.Workers":
+			downloadManager.Resize()
+		case "Cache.Format":
+			if newConfig.Cache.Format == "opus" {
+				if err := checkOpusEncoder(); err != nil {
+					description += fmt.Sprintf(" (songs cannot be transcoded: %v)", err)
+				}
+			}
+		case "HTTP.Enabled", "HTTP.Address", "HTTP.WebInterface", "HTTP.Metrics":
+			StopAPIServer()
+			if newConfig.HTTP.Enabled && connected {
+				if err := StartAPIServer(); err != nil {
+					description += fmt.Sprintf(" (could not start HTTP API: %v)", err)
+				}
+			}
+		case "Control.Enabled", "Control.Socket":
+			StopControlSocket()
+			if newConfig.Control.Enabled {
+				if err := StartControlSocket(); err != nil {
+					description += fmt.Sprintf(" (could not open control socket: %v)", err)
+				}
+			}
+		case "MPD.Enabled", "MPD.Address":
+			StopMPDServer()
+			if newConfig.MPD.Enabled && connected {
+				if err := StartMPDServer(); err != nil {
+					description += fmt.Sprintf(" (could not start MPD server: %v)", err)
+				}
+			}
+		default:
+			if strings.HasPrefix(change.Key, "Connection.") || strings.HasPrefix(change.Key, "Storage.") {
+				description += " (takes effect after MumbleDJ is restarted)"
+			} else if strings.HasPrefix(change.Key, "Logging.") {
+				ConfigureLogging(newConfig)
+			}
+		}
+		report = append(report, description)
+	}
+
+	if dj.audioStream != nil {
+		volume := dj.audioStream.Volume
+		if volume < newConfig.Volume.LowestVolume {
+			dj.audioStream.Volume = newConfig.Volume.LowestVolume
+		} else if volume > newConfig.Volume.HighestVolume {
+			dj.audioStream.Volume = newConfig.Volume.HighestVolume
+		}
+		if dj.audioStream.Volume != volume {
+			report = append(report, fmt.Sprintf("Current volume clamped from %.2f to %.2f.", volume, dj.audioStream.Volume))
+		}
+	}
+	return report
+}
+
+// reloadMutex prevents !reload and the configuration watcher from reloading at the same time.
+var reloadMutex sync.Mutex
+
+// reloadConfiguration reads the configuration file and applies any changes to the running bot.
+// A description of each change is returned. If the configuration cannot be loaded, the current
+// configuration is kept and the error is returned.
+func reloadConfiguration() ([]string, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	newConfig, messages, err := readConfigurationAndMessages()
+	if err != nil {
+		return nil, err
+	}
+	return applyConfiguration(newConfig, messages), nil
+}
+
+// configErrorLines splits an error returned while loading the configuration into one line per
+// problem.
+func configErrorLines(err error) []string {
+	if errs, ok := err.(ConfigErrors); ok {
+		lines := make([]string, len(errs))
+		for i, configErr := range errs {
+			lines[i] = configErr.Error()
+		}
+		return lines
+	}
+	return []string{err.Error()}
+}
+
+// configWatcher watches the directory containing the configuration file while AutoReload is enabled.
+var configWatcher *fsnotify.Watcher
+
+// StartConfigWatcher begins reloading the configuration whenever the configuration file is
+// written. The containing directory is watched, rather than the file itself, so that editors
+// which replace the file on save are handled.
+func StartConfigWatcher() error {
+	if configWatcher != nil {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	path := filepath.Clean(configFilePath())
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	configWatcher = watcher
+
+	go func() {
+		// Editors often write a file in several steps, so wait for writes to settle before reloading.
+		var pending *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != path || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if pending != nil {
+					pending.Stop()
+				}
+				pending = time.AfterFunc(500*time.Millisecond, autoReload)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.WithError(err).Error("An error occurred while watching the configuration file.")
+			}
+		}
+	}()
+	return nil
+}
+
+// StopConfigWatcher stops reloading the configuration when the configuration file changes.
+func StopConfigWatcher() {
+	if configWatcher != nil {
+		configWatcher.Close()
+		configWatcher = nil
+	}
+}
+
+// autoReload reloads the configuration after the configuration file changes on disk, and logs
+// the result. It holds commandMutex while doing so, as !reload does, so that commands never see
+// the configuration half applied.
+func autoReload() {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+	changes, err := reloadConfiguration()
+	if err != nil {
+		logger.WithField("errors", configErrorLines(err)).Error("The configuration file changed but could not be reloaded.")
+	} else if len(changes) == 0 {
+		logger.Info("The configuration file changed, but no settings were changed.")
+	} else {
+		logger.WithField("changes", changes).Info("The configuration has been reloaded.")
+	}
+}
@@ -0,0 +1,349 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * ctl.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The running bot listens on a Unix domain socket so that it can be controlled from its host with
+// `mumbledj ctl <command> [args]`. Only the owner of the socket file may connect to it, and
+// commands sent through it are performed with admin rights. Each connection carries a single
+// JSON-encoded ctlRequest, which is answered with a ctlResponse.
+
+// ctlRequest is a command sent through the control socket. User is the login name of the user
+// that ran `mumbledj ctl`.
+type ctlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	User    string   `json:"user"`
+}
+
+// ctlResponse is the answer to a ctlRequest. Output holds the messages the command produced.
+type ctlResponse struct {
+	Output []string `json:"output"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// CtlSender is a CommandSender for commands sent through the control socket. Messages sent to it
+// are collected and returned in the response.
+type CtlSender struct {
+	name     string
+	messages []string
+	kill     bool
+}
+
+// Name returns the login name of the user that sent the command, or "ctl" if it is not known.
+func (s *CtlSender) Name() string {
+	if s.name == "" {
+		return "ctl"
+	}
+	return s.name
+}
+
+// Send records a message so that it may be included in the response.
+func (s *CtlSender) Send(message string) {
+	if text := PlainText(message); text != "" {
+		s.messages = append(s.messages, text)
+	}
+}
+
+// InChannel always returns false, so that the response includes a copy of every message that
+// the command sends to the channel.
+func (s *CtlSender) InChannel() bool {
+	return false
+}
+
+// ctlCommand describes a command that may be sent through the control socket. If Connected is
+// true, the command can only be performed while MumbleDJ is connected to a server. Handler is
+// called while commandMutex is held.
+type ctlCommand struct {
+	Name        string
+	Usage       string
+	Description string
+	Connected   bool
+	Handler     func(sender *CtlSender, args []string) error
+}
+
+// ctlCommands lists every command that may be sent through the control socket.
+var ctlCommands []ctlCommand
+
+func init() {
+	// ctlCommands refers to ctlReload, which can reopen the control socket and so refers back to
+	// ctlCommands, so it must be set here.
+	ctlCommands = []ctlCommand{
+		{"status", "", "Shows the current song, the volume and the length of the queue.", false, ctlStatus},
+		{"queue", "", "Lists the songs in the queue.", false, ctlQueue},
+		{"add", "<url>", "Adds a YouTube video or playlist to the queue.", true, ctlAdd},
+		{"skip", "[playlist]", "Skips the current song, or the current playlist.", true, ctlSkip},
+		{"volume", "[volume]", "Shows the volume, or changes it.", true, ctlVolume},
+		{"reload", "", "Reloads the configuration file.", false, ctlReload},
+		{"kill", "", "Stops MumbleDJ, deleting the downloaded songs unless the cache is enabled.", false, ctlKill},
+	}
+}
+
+// ctlStatus describes the current song, the volume and the length of the queue.
+func ctlStatus(sender *CtlSender, args []string) error {
+	if !dj.connected {
+		sender.messages = append(sender.messages, "Not connected to a server.")
+		return nil
+	}
+	if dj.HasCurrentSong() {
+		song := dj.queue.CurrentSong()
+		state := "Playing"
+		if dj.paused {
+			state = "Paused"
+		}
+		sender.messages = append(sender.messages, fmt.Sprintf("%s: \"%s\" (%s), added by %s. %s of %s elapsed.",
+			state, song.Title(), song.ID(), song.Submitter(), formatElapsed(dj.Elapsed()), song.Duration()))
+	} else {
+		sender.messages = append(sender.messages, "Nothing is playing.")
+	}
+	sender.messages = append(sender.messages,
+		fmt.Sprintf("Volume: %.2f (allowed range %.2f to %.2f).", dj.audioStream.Volume, dj.conf.Volume.LowestVolume, dj.conf.Volume.HighestVolume),
+		fmt.Sprintf("Songs in the queue: %d.", dj.queue.Len()))
+	return nil
+}
+
+// ctlQueue lists every song in the queue, starting with the current song.
+func ctlQueue(sender *CtlSender, args []string) error {
+	if dj.queue.Len() == 0 {
+		sender.messages = append(sender.messages, "The queue is empty.")
+	}
+	dj.queue.Traverse(func(i int, song Song) {
+		line := fmt.Sprintf("%d. \"%s\" (%s, %s), added by %s", i+1, song.Title(), song.ID(), song.Duration(), song.Submitter())
+		if song.Playlist() != nil {
+			line += fmt.Sprintf(" from the playlist \"%s\"", song.Playlist().Title())
+		}
+		sender.messages = append(sender.messages, line+".")
+	})
+	return nil
+}
+
+// ctlAdd performs !add with the URL given.
+func ctlAdd(sender *CtlSender, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: mumbledj ctl add <url>")
+	}
+	add(sender, sender.Name(), args[0])
+	return nil
+}
+
+// ctlSkip performs !forceskip, or !forceskipplaylist if given "playlist".
+func ctlSkip(sender *CtlSender, args []string) error {
+	if len(args) > 1 || (len(args) == 1 && args[0] != "playlist") {
+		return errors.New("Usage: mumbledj ctl skip [playlist]")
+	}
+	skip(sender, sender.Name(), true, len(args) == 1)
+	return nil
+}
+
+// ctlVolume performs !volume, with the volume given if any.
+func ctlVolume(sender *CtlSender, args []string) error {
+	if len(args) > 1 {
+		return errors.New("Usage: mumbledj ctl volume [volume]")
+	}
+	volume(sender, sender.Name(), strings.Join(args, ""))
+	return nil
+}
+
+// ctlReload performs !reload.
+func ctlReload(sender *CtlSender, args []string) error {
+	reload(sender)
+	return nil
+}
+
+// ctlKill performs !kill once the response has been sent.
+func ctlKill(sender *CtlSender, args []string) error {
+	sender.messages = append(sender.messages, "Stopping MumbleDJ.")
+	sender.kill = true
+	return nil
+}
+
+// formatElapsed formats a position within a song in the same form as Song.Duration.
+func formatElapsed(elapsed time.Duration) string {
+	seconds := int(elapsed.Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// controlSocketPath returns the path of the control socket set in the [Control] section of conf,
+// or mumbledj.sock in the state directory if none is set.
+func controlSocketPath(conf DjConfig) string {
+	if conf.Control.Socket != "" {
+		return conf.Control.Socket
+	}
+	return filepath.Join(ResolveStoragePaths(conf).State, "mumbledj.sock")
+}
+
+// controlListener is the listener for the control socket while it is open.
+var controlListener net.Listener
+
+// StartControlSocket starts listening on the control socket, unless this is already being done.
+// A socket file left behind by a previous run is replaced, but one that another copy of MumbleDJ
+// is listening on is not.
+func StartControlSocket() error {
+	if controlListener != nil {
+		return nil
+	}
+	path := controlSocketPath(dj.conf)
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("Another copy of MumbleDJ is already listening on %s.", path)
+		}
+		os.Remove(path)
+	}
+	// Anyone who can connect to the socket can run admin commands, so it is created readable and
+	// writable only by the user MumbleDJ runs as, rather than made so once it already exists.
+	umask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return err
+	}
+	controlListener = listener
+	go func(listener net.Listener) {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveControlConn(conn)
+		}
+	}(listener)
+	return nil
+}
+
+// StopControlSocket stops listening on the control socket and removes the socket file.
+func StopControlSocket() {
+	if controlListener != nil {
+		controlListener.Close()
+		controlListener = nil
+	}
+}
+
+// serveControlConn reads a command from a control socket connection, performs it and sends the
+// response.
+func serveControlConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	var request ctlRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&request); err != nil {
+		json.NewEncoder(conn).Encode(ctlResponse{Error: "The request is not valid JSON."})
+		return
+	}
+
+	var command *ctlCommand
+	for i := range ctlCommands {
+		if ctlCommands[i].Name == request.Command {
+			command = &ctlCommands[i]
+		}
+	}
+	if command == nil {
+		json.NewEncoder(conn).Encode(ctlResponse{Error: fmt.Sprintf("Unknown command %q.", request.Command)})
+		return
+	}
+
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
+	sender := &CtlSender{name: request.User}
+	response := ctlResponse{}
+	if command.Connected && !dj.connected {
+		response.Error = "MumbleDJ is not connected to a server."
+	} else if err := command.Handler(sender, request.Args); err != nil {
+		response.Error = err.Error()
+	}
+	response.Output = sender.messages
+	json.NewEncoder(conn).Encode(response)
+
+	if sender.kill {
+		conn.Close()
+		kill(sender)
+	}
+}
+
+// runCtl performs `mumbledj ctl`, sending a command to the running bot through the control socket
+// and printing the response. The exit status is returned.
+func runCtl(args []string) int {
+	flags := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to the configuration file (default mumbledj.gcfg in the configuration directory)")
+	socket := flags.String("socket", "", "path to the control socket (default from the configuration file)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mumbledj ctl [-config file] [-socket path] <command> [args]\n\nCommands:\n")
+		for _, command := range ctlCommands {
+			fmt.Fprintf(os.Stderr, "  %-18s %s\n", strings.TrimSpace(command.Name+" "+command.Usage), command.Description)
+		}
+		fmt.Fprintf(os.Stderr, "\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	path := *socket
+	if path == "" {
+		dj.configFile = *configFile
+		conf, err := readConfiguration(configFilePath())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		path = controlSocketPath(conf)
+	}
+
+	request := ctlRequest{Command: flags.Arg(0), Args: flags.Args()[1:]}
+	if currentUser, err := user.Current(); err == nil {
+		request.User = currentUser.Username
+	}
+
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to MumbleDJ at %s: %v\nIs MumbleDJ running with the control socket enabled?\n", path, err)
+		return 1
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	var response ctlResponse
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not send the command: %v\n", err)
+		return 1
+	}
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the response: %v\n", err)
+		return 1
+	}
+	for _, line := range response.Output {
+		fmt.Println(line)
+	}
+	if response.Error != "" {
+		fmt.Fprintln(os.Stderr, response.Error)
+		return 1
+	}
+	return 0
+}
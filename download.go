@@ -0,0 +1,289 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * download.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons a download may fail, as classified from the downloader's error output.
+var (
+	ErrGeoBlocked      = errors.New("The video is not available in this country.")
+	ErrVideoRemoved    = errors.New("The video has been removed or made private.")
+	ErrAgeRestricted   = errors.New("The video is age-restricted.")
+	ErrRateLimited     = errors.New("YouTube is limiting downloads.")
+	ErrDownloadTimeout = errors.New("The download took too long.")
+	ErrDownloadFailed  = errors.New("Song download failed.")
+)
+
+// downloadPatterns maps phrases found in the downloader's error output to the reason for the
+// failure. Patterns are checked in order, as some messages match more than one reason.
+var downloadPatterns = []struct {
+	reason  error
+	phrases []string
+}{
+	{ErrGeoBlocked, []string{"not available in your country", "geo restriction", "geo-restricted", "blocked it in your country"}},
+	{ErrAgeRestricted, []string{"confirm your age", "age-restricted", "age restricted", "inappropriate for some users"}},
+	{ErrRateLimited, []string{"http error 429", "too many requests"}},
+	{ErrVideoRemoved, []string{"video unavailable", "has been removed", "private video", "no longer available", "has been terminated", "does not exist"}},
+}
+
+// DownloadError describes a failed download. Reason is one of the Err values above, and Output
+// holds the last lines written to standard error by the downloader.
+type DownloadError struct {
+	Reason   error
+	Output   string
+	Attempts int
+}
+
+// Error returns the reason for the failure.
+func (e *DownloadError) Error() string {
+	return e.Reason.Error()
+}
+
+// Unwrap returns the reason for the failure, so that it may be checked with errors.Is.
+func (e *DownloadError) Unwrap() error {
+	return e.Reason
+}
+
+// Retryable returns whether the download may succeed if it is tried again. Videos that are
+// blocked, removed or age-restricted will not download however many times they are tried.
+func (e *DownloadError) Retryable() bool {
+	return e.Reason != ErrGeoBlocked && e.Reason != ErrVideoRemoved && e.Reason != ErrAgeRestricted
+}
+
+// classifyDownloadError returns the reason for a failed download given the downloader's error
+// output.
+func classifyDownloadError(output string) error {
+	output = strings.ToLower(output)
+	for _, pattern := range downloadPatterns {
+		for _, phrase := range pattern.phrases {
+			if strings.Contains(output, phrase) {
+				return pattern.reason
+			}
+		}
+	}
+	return ErrDownloadFailed
+}
+
+// downloadReason returns the name of the reason err failed with, for use in messages and metrics.
+func downloadReason(err error) string {
+	switch {
+	case errors.Is(err, ErrGeoBlocked):
+		return "geo_blocked"
+	case errors.Is(err, ErrVideoRemoved):
+		return "removed"
+	case errors.Is(err, ErrAgeRestricted):
+		return "age_restricted"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrDownloadTimeout):
+		return "timeout"
+	}
+	return "unknown"
+}
+
+// lastLines returns at most the last n lines of output.
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// downloaderCommand returns the command that runs the downloader set in the [Downloader] section
+// of conf with the given arguments, along with the proxy, cookies file and extra arguments set
+// there. The command is stopped when ctx is done.
+func downloaderCommand(ctx context.Context, conf DjConfig, args ...string) *exec.Cmd {
+	var common []string
+	if conf.Downloader.Proxy != "" {
+		common = append(common, "--proxy", conf.Downloader.Proxy)
+	}
+	if conf.Downloader.CookiesFile != "" {
+		common = append(common, "--cookies", conf.Downloader.CookiesFile)
+	}
+	common = append(common, conf.Downloader.Arguments...)
+	return exec.CommandContext(ctx, conf.Downloader.Executable, append(common, args...)...)
+}
+
+// downloadCommand returns the command that downloads the video with the given ID to filename in
+// the songs directory, choosing the first of the Formats set in the [Downloader] section that is
+// available.
+func downloadCommand(ctx context.Context, id, filename string) *exec.Cmd {
+	return downloaderCommand(ctx, dj.conf, "--no-mtime", "--output", songPath(filename),
+		"--format", strings.Join(dj.conf.Downloader.Formats, "/"), "--", id)
+}
+
+// CheckDownloader checks that the downloader set in the [Downloader] section of conf can be run,
+// and returns the version it reports.
+func CheckDownloader(conf DjConfig) (string, error) {
+	if _, err := exec.LookPath(conf.Downloader.Executable); err != nil {
+		return "", fmt.Errorf("The downloader %s could not be found.", conf.Downloader.Executable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, conf.Downloader.Executable, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("The downloader %s could not be run: %v", conf.Downloader.Executable, err)
+	}
+	return lastLines(string(output), 1), nil
+}
+
+// pendingDownload is a download that is waiting for a worker or running. Everyone asking for the
+// same song while it is pending waits for it to finish and receives the same result.
+type pendingDownload struct {
+	done chan struct{}
+	err  error
+}
+
+// DownloadManager runs the downloads of songs, at most Workers of them at once as set in the
+// [Downloader] section. Each attempt is stopped after Timeout seconds, and failed attempts are
+// tried again up to Retries times, waiting RetryDelay seconds before the first retry and twice as
+// long before each one after that.
+type DownloadManager struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	running int
+	pending map[string]*pendingDownload
+}
+
+// NewDownloadManager creates a DownloadManager with no downloads running.
+func NewDownloadManager() *DownloadManager {
+	m := &DownloadManager{pending: make(map[string]*pendingDownload)}
+	m.cond = sync.NewCond(&m.mutex)
+	return m
+}
+
+// downloadManager runs the downloads of every song.
+var downloadManager = NewDownloadManager()
+
+// Download downloads s into the songs directory unless it is already there, using the command
+// returned by command, which is run within the given context. Songs stored as Opus are then
+// transcoded, and the song is added to the cache if it is enabled. If s is already being
+// downloaded, Download waits for that download to finish instead of starting another.
+func (m *DownloadManager) Download(s Song, command func(ctx context.Context) *exec.Cmd) error {
+	filename := s.Filename()
+	m.mutex.Lock()
+	if pending, ok := m.pending[filename]; ok {
+		m.mutex.Unlock()
+		<-pending.done
+		return pending.err
+	}
+	if _, err := os.Stat(songPath(filename)); err == nil {
+		m.mutex.Unlock()
+		return nil
+	}
+	pending := &pendingDownload{done: make(chan struct{})}
+	m.pending[filename] = pending
+	m.mutex.Unlock()
+
+	pending.err = m.run(s, command)
+
+	m.mutex.Lock()
+	delete(m.pending, filename)
+	m.mutex.Unlock()
+	close(pending.done)
+	return pending.err
+}
+
+// run downloads s once a worker is free, retrying failed attempts that may succeed if tried again.
+// The worker is given up while waiting to retry, so that other downloads may use it meanwhile.
+func (m *DownloadManager) run(s Song, command func(ctx context.Context) *exec.Cmd) error {
+	delay := time.Duration(dj.conf.Downloader.RetryDelay) * time.Second
+	var err *DownloadError
+	for attempt := 1; ; attempt++ {
+		m.acquire()
+		if err = m.attempt(s, command); err == nil {
+			break
+		}
+		m.release()
+		err.Attempts = attempt
+		if !err.Retryable() || attempt > dj.conf.Downloader.Retries || dj.ShuttingDown() {
+			return err
+		}
+		logger.WithFields(songFields(s)).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"reason":  downloadReason(err),
+			"wait":    delay.String(),
+		}).Warn("Song download failed, trying again.")
+		time.Sleep(delay)
+		delay *= 2
+	}
+	defer m.release()
+
+	if downloadFilename(s.Filename()) != s.Filename() {
+		if err := TranscodeSong(s.Filename()); err != nil {
+			logger.WithFields(songFields(s)).WithError(err).Error("An error occurred while transcoding the song.")
+			return &DownloadError{Reason: ErrDownloadFailed, Attempts: 1}
+		}
+	}
+	if dj.conf.Cache.Enabled {
+		dj.cache.Add(s)
+		dj.cache.CheckMaximumDirectorySize()
+	}
+	return nil
+}
+
+// attempt runs the downloader once for s, stopping it after the Timeout set in the [Downloader]
+// section.
+func (m *DownloadManager) attempt(s Song, command func(ctx context.Context) *exec.Cmd) *DownloadError {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(dj.conf.Downloader.Timeout)*time.Second)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := command(ctx)
+	cmd.Stderr = &stderr
+	started := time.Now()
+	err := downloads.Run(cmd, s.Filename())
+	downloadDuration.Observe(time.Since(started).Seconds())
+	if err == nil {
+		return nil
+	}
+
+	output := lastLines(stderr.String(), 5)
+	if ctx.Err() == context.DeadlineExceeded {
+		return &DownloadError{Reason: ErrDownloadTimeout, Output: output}
+	}
+	return &DownloadError{Reason: classifyDownloadError(output), Output: output}
+}
+
+// acquire waits until fewer than Workers downloads are running, then counts this one as running.
+func (m *DownloadManager) acquire() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for m.running >= dj.conf.Downloader.Workers {
+		m.cond.Wait()
+	}
+	m.running++
+}
+
+// release counts a download as finished, letting a waiting download start.
+func (m *DownloadManager) release() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.running--
+	m.cond.Broadcast()
+}
+
+// Resize lets waiting downloads start after the number of Workers has been changed.
+func (m *DownloadManager) Resize() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.cond.Broadcast()
+}
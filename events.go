@@ -0,0 +1,353 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * events.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"sync"
+)
+
+// Events are published to dj.events whenever the queue or playback state changes, and are
+// delivered to every attached EventListener in the order in which the listeners were attached.
+// Listeners are called synchronously, so any slow work (such as network requests) must be done
+// in a separate goroutine. Sender is nil for events that were not caused by a command.
+
+// SongQueuedEvent is published when a song is added to the queue.
+type SongQueuedEvent struct {
+	Sender CommandSender
+	Song   Song
+}
+
+// PlaylistQueuedEvent is published when the songs of a playlist are added to the queue.
+type PlaylistQueuedEvent struct {
+	Sender   CommandSender
+	Playlist Playlist
+	Songs    int
+}
+
+// SongRemovedEvent is published when a song that has not started playing is removed from the
+// queue.
+type SongRemovedEvent struct {
+	Sender CommandSender
+	Song   Song
+}
+
+// SongStartedEvent is published when a song starts playing.
+type SongStartedEvent struct {
+	Song Song
+}
+
+// SongFinishedEvent is published when a song stops playing, whether it ended or was skipped.
+type SongFinishedEvent struct {
+	Song Song
+}
+
+// SkipVotedEvent is published when a user votes to skip the current song or playlist without
+// the vote causing a skip.
+type SkipVotedEvent struct {
+	Sender   CommandSender
+	Song     Song
+	Playlist bool
+}
+
+// SongSkippedEvent is published when the current song is skipped. Forced is true if an admin
+// forced the skip, and BySubmitter is true if the submitter of the song skipped it.
+type SongSkippedEvent struct {
+	Sender      CommandSender
+	Song        Song
+	Forced      bool
+	BySubmitter bool
+}
+
+// PlaylistSkippedEvent is published when the current playlist is skipped. Forced is true if an
+// admin forced the skip, and BySubmitter is true if the submitter of the playlist skipped it.
+type PlaylistSkippedEvent struct {
+	Sender      CommandSender
+	Playlist    Playlist
+	Forced      bool
+	BySubmitter bool
+}
+
+// PlaybackPausedEvent is published when the current song is paused.
+type PlaybackPausedEvent struct {
+	Sender CommandSender
+	Song   Song
+}
+
+// PlaybackResumedEvent is published when the paused song is resumed.
+type PlaybackResumedEvent struct {
+	Sender CommandSender
+	Song   Song
+}
+
+// VolumeChangedEvent is published when a user changes the volume.
+type VolumeChangedEvent struct {
+	Sender CommandSender
+	Volume float32
+}
+
+// QueueResetEvent is published when the queue is cleared.
+type QueueResetEvent struct {
+	Sender CommandSender
+}
+
+// DownloadFailedEvent is published when the audio for a song could not be downloaded.
+type DownloadFailedEvent struct {
+	Sender CommandSender
+	Song   Song
+	Err    error
+}
+
+// EventListener is implemented by types that wish to be notified of MumbleDJ events.
+type EventListener interface {
+	OnSongQueued(e *SongQueuedEvent)
+	OnPlaylistQueued(e *PlaylistQueuedEvent)
+	OnSongRemoved(e *SongRemovedEvent)
+	OnSongStarted(e *SongStartedEvent)
+	OnSongFinished(e *SongFinishedEvent)
+	OnSkipVoted(e *SkipVotedEvent)
+	OnSongSkipped(e *SongSkippedEvent)
+	OnPlaylistSkipped(e *PlaylistSkippedEvent)
+	OnPlaybackPaused(e *PlaybackPausedEvent)
+	OnPlaybackResumed(e *PlaybackResumedEvent)
+	OnVolumeChanged(e *VolumeChangedEvent)
+	OnQueueReset(e *QueueResetEvent)
+	OnDownloadFailed(e *DownloadFailedEvent)
+}
+
+// Listener is an EventListener that calls the function set for each type of event. Events
+// without a function are ignored.
+type Listener struct {
+	SongQueued      func(e *SongQueuedEvent)
+	PlaylistQueued  func(e *PlaylistQueuedEvent)
+	SongRemoved     func(e *SongRemovedEvent)
+	SongStarted     func(e *SongStartedEvent)
+	SongFinished    func(e *SongFinishedEvent)
+	SkipVoted       func(e *SkipVotedEvent)
+	SongSkipped     func(e *SongSkippedEvent)
+	PlaylistSkipped func(e *PlaylistSkippedEvent)
+	PlaybackPaused  func(e *PlaybackPausedEvent)
+	PlaybackResumed func(e *PlaybackResumedEvent)
+	VolumeChanged   func(e *VolumeChangedEvent)
+	QueueReset      func(e *QueueResetEvent)
+	DownloadFailed  func(e *DownloadFailedEvent)
+}
+
+// OnSongQueued calls l.SongQueued if it is set.
+func (l Listener) OnSongQueued(e *SongQueuedEvent) {
+	if l.SongQueued != nil {
+		l.SongQueued(e)
+	}
+}
+
+// OnPlaylistQueued calls l.PlaylistQueued if it is set.
+func (l Listener) OnPlaylistQueued(e *PlaylistQueuedEvent) {
+	if l.PlaylistQueued != nil {
+		l.PlaylistQueued(e)
+	}
+}
+
+// OnSongRemoved calls l.SongRemoved if it is set.
+func (l Listener) OnSongRemoved(e *SongRemovedEvent) {
+	if l.SongRemoved != nil {
+		l.SongRemoved(e)
+	}
+}
+
+// OnSongStarted calls l.SongStarted if it is set.
+func (l Listener) OnSongStarted(e *SongStartedEvent) {
+	if l.SongStarted != nil {
+		l.SongStarted(e)
+	}
+}
+
+// OnSongFinished calls l.SongFinished if it is set.
+func (l Listener) OnSongFinished(e *SongFinishedEvent) {
+	if l.SongFinished != nil {
+		l.SongFinished(e)
+	}
+}
+
+// OnSkipVoted calls l.SkipVoted if it is set.
+func (l Listener) OnSkipVoted(e *SkipVotedEvent) {
+	if l.SkipVoted != nil {
+		l.SkipVoted(e)
+	}
+}
+
+// OnSongSkipped calls l.SongSkipped if it is set.
+func (l Listener) OnSongSkipped(e *SongSkippedEvent) {
+	if l.SongSkipped != nil {
+		l.SongSkipped(e)
+	}
+}
+
+// OnPlaylistSkipped calls l.PlaylistSkipped if it is set.
+func (l Listener) OnPlaylistSkipped(e *PlaylistSkippedEvent) {
+	if l.PlaylistSkipped != nil {
+		l.PlaylistSkipped(e)
+	}
+}
+
+// OnPlaybackPaused calls l.PlaybackPaused if it is set.
+func (l Listener) OnPlaybackPaused(e *PlaybackPausedEvent) {
+	if l.PlaybackPaused != nil {
+		l.PlaybackPaused(e)
+	}
+}
+
+// OnPlaybackResumed calls l.PlaybackResumed if it is set.
+func (l Listener) OnPlaybackResumed(e *PlaybackResumedEvent) {
+	if l.PlaybackResumed != nil {
+		l.PlaybackResumed(e)
+	}
+}
+
+// OnVolumeChanged calls l.VolumeChanged if it is set.
+func (l Listener) OnVolumeChanged(e *VolumeChangedEvent) {
+	if l.VolumeChanged != nil {
+		l.VolumeChanged(e)
+	}
+}
+
+// OnQueueReset calls l.QueueReset if it is set.
+func (l Listener) OnQueueReset(e *QueueResetEvent) {
+	if l.QueueReset != nil {
+		l.QueueReset(e)
+	}
+}
+
+// OnDownloadFailed calls l.DownloadFailed if it is set.
+func (l Listener) OnDownloadFailed(e *DownloadFailedEvent) {
+	if l.DownloadFailed != nil {
+		l.DownloadFailed(e)
+	}
+}
+
+// Detacher is returned when a listener is attached to an EventBus, and removes the listener
+// from the bus.
+type Detacher interface {
+	Detach()
+}
+
+// EventBus delivers published events to every attached EventListener. Events are published by
+// calling the matching EventListener method on the bus itself, e.g. dj.events.OnSongStarted(...).
+type EventBus struct {
+	mutex     sync.Mutex
+	listeners []*attachedListener
+}
+
+// attachedListener is a listener attached to an EventBus.
+type attachedListener struct {
+	bus      *EventBus
+	listener EventListener
+}
+
+// NewEventBus creates an EventBus without any listeners.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Attach adds a listener to the bus. It will be called for every event published from now on.
+func (b *EventBus) Attach(listener EventListener) Detacher {
+	attached := &attachedListener{
+		bus:      b,
+		listener: listener,
+	}
+	b.mutex.Lock()
+	b.listeners = append(b.listeners, attached)
+	b.mutex.Unlock()
+	return attached
+}
+
+// Detach removes the listener from the bus it was attached to.
+func (a *attachedListener) Detach() {
+	a.bus.mutex.Lock()
+	defer a.bus.mutex.Unlock()
+	for i, attached := range a.bus.listeners {
+		if attached == a {
+			a.bus.listeners = append(a.bus.listeners[:i:i], a.bus.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// each calls publish with every listener attached to the bus. Listeners may attach or detach
+// listeners while an event is being delivered.
+func (b *EventBus) each(publish func(listener EventListener)) {
+	b.mutex.Lock()
+	listeners := b.listeners
+	b.mutex.Unlock()
+	for _, attached := range listeners {
+		publish(attached.listener)
+	}
+}
+
+// OnSongQueued publishes a SongQueuedEvent.
+func (b *EventBus) OnSongQueued(e *SongQueuedEvent) {
+	b.each(func(l EventListener) { l.OnSongQueued(e) })
+}
+
+// OnPlaylistQueued publishes a PlaylistQueuedEvent.
+func (b *EventBus) OnPlaylistQueued(e *PlaylistQueuedEvent) {
+	b.each(func(l EventListener) { l.OnPlaylistQueued(e) })
+}
+
+// OnSongRemoved publishes a SongRemovedEvent.
+func (b *EventBus) OnSongRemoved(e *SongRemovedEvent) {
+	b.each(func(l EventListener) { l.OnSongRemoved(e) })
+}
+
+// OnSongStarted publishes a SongStartedEvent.
+func (b *EventBus) OnSongStarted(e *SongStartedEvent) {
+	b.each(func(l EventListener) { l.OnSongStarted(e) })
+}
+
+// OnSongFinished publishes a SongFinishedEvent.
+func (b *EventBus) OnSongFinished(e *SongFinishedEvent) {
+	b.each(func(l EventListener) { l.OnSongFinished(e) })
+}
+
+// OnSkipVoted publishes a SkipVotedEvent.
+func (b *EventBus) OnSkipVoted(e *SkipVotedEvent) {
+	b.each(func(l EventListener) { l.OnSkipVoted(e) })
+}
+
+// OnSongSkipped publishes a SongSkippedEvent.
+func (b *EventBus) OnSongSkipped(e *SongSkippedEvent) {
+	b.each(func(l EventListener) { l.OnSongSkipped(e) })
+}
+
+// OnPlaylistSkipped publishes a PlaylistSkippedEvent.
+func (b *EventBus) OnPlaylistSkipped(e *PlaylistSkippedEvent) {
+	b.each(func(l EventListener) { l.OnPlaylistSkipped(e) })
+}
+
+// OnPlaybackPaused publishes a PlaybackPausedEvent.
+func (b *EventBus) OnPlaybackPaused(e *PlaybackPausedEvent) {
+	b.each(func(l EventListener) { l.OnPlaybackPaused(e) })
+}
+
+// OnPlaybackResumed publishes a PlaybackResumedEvent.
+func (b *EventBus) OnPlaybackResumed(e *PlaybackResumedEvent) {
+	b.each(func(l EventListener) { l.OnPlaybackResumed(e) })
+}
+
+// OnVolumeChanged publishes a VolumeChangedEvent.
+func (b *EventBus) OnVolumeChanged(e *VolumeChangedEvent) {
+	b.each(func(l EventListener) { l.OnVolumeChanged(e) })
+}
+
+// OnQueueReset publishes a QueueResetEvent.
+func (b *EventBus) OnQueueReset(e *QueueResetEvent) {
+	b.each(func(l EventListener) { l.OnQueueReset(e) })
+}
+
+// OnDownloadFailed publishes a DownloadFailedEvent.
+func (b *EventBus) OnDownloadFailed(e *DownloadFailedEvent) {
+	b.each(func(l EventListener) { l.OnDownloadFailed(e) })
+}
@@ -0,0 +1,363 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * httpapi.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// APISender is a CommandSender for commands issued through the HTTP API. Messages sent to it
+// are collected and returned in the response.
+type APISender struct {
+	name     string
+	messages []string
+}
+
+// Name returns the name associated with the token used to make the request.
+func (s *APISender) Name() string {
+	return s.name
+}
+
+// Send records a message so that it may be included in the response.
+func (s *APISender) Send(message string) {
+	s.messages = append(s.messages, strings.TrimSpace(message))
+}
+
+// InChannel always returns false, so that API clients receive a copy of every message that
+// their commands send to the channel.
+func (s *APISender) InChannel() bool {
+	return false
+}
+
+// APISong is the JSON representation of a Song.
+type APISong struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Duration  string `json:"duration"`
+	Thumbnail string `json:"thumbnail"`
+	Submitter string `json:"submitter"`
+	Playlist  string `json:"playlist,omitempty"`
+}
+
+// NewAPISong returns the JSON representation of a Song.
+func NewAPISong(s Song) APISong {
+	song := APISong{
+		ID:        s.ID(),
+		Title:     s.Title(),
+		Duration:  s.Duration(),
+		Thumbnail: s.Thumbnail(),
+		Submitter: s.Submitter(),
+	}
+	if s.Playlist() != nil {
+		song.Playlist = s.Playlist().Title()
+	}
+	return song
+}
+
+// apiCurrentSong is the response to GET /api/current.
+type apiCurrentSong struct {
+	Playing bool     `json:"playing"`
+	Paused  bool     `json:"paused"`
+	Elapsed int      `json:"elapsed"`
+	Song    *APISong `json:"song"`
+}
+
+// apiQueue is the response to GET /api/queue.
+type apiQueue struct {
+	Songs []APISong `json:"songs"`
+}
+
+// apiVolume is the response to GET /api/volume.
+type apiVolume struct {
+	Volume  float32 `json:"volume"`
+	Lowest  float32 `json:"lowest"`
+	Highest float32 `json:"highest"`
+}
+
+// apiCache is the response to GET /api/cache.
+type apiCache struct {
+	Enabled bool  `json:"enabled"`
+	Songs   int   `json:"songs"`
+	Size    int64 `json:"size"`
+}
+
+// apiMessages is the response to endpoints that perform a command. It holds the messages that
+// the command sent back to the client.
+type apiMessages struct {
+	Messages []string `json:"messages"`
+}
+
+// apiError is the response to a request that could not be performed.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiEndpoint describes an endpoint of the HTTP API. Permission returns the setting from the
+// [Permissions] section that makes the endpoint admin-only, matching the equivalent chat command.
+// Handler is called while commandMutex is held, and returns the status code and response body.
+type apiEndpoint struct {
+	Method     string
+	Path       string
+	Permission func() bool
+	Handler    func(sender *APISender, r *http.Request) (int, interface{})
+}
+
+// apiEndpoints lists every endpoint of the HTTP API.
+var apiEndpoints = []apiEndpoint{
+	{"GET", "/api/current", func() bool { return dj.conf.Permissions.AdminCurrentSong }, apiGetCurrentSong},
+	{"GET", "/api/queue", func() bool { return dj.conf.Permissions.AdminNumSongs }, apiGetQueue},
+	{"POST", "/api/add", func() bool { return dj.conf.Permissions.AdminAdd }, apiAdd},
+	{"POST", "/api/skip", func() bool { return dj.conf.Permissions.AdminSkip }, apiSkip},
+	{"GET", "/api/volume", func() bool { return dj.conf.Permissions.AdminVolume }, apiGetVolume},
+	{"POST", "/api/volume", func() bool { return dj.conf.Permissions.AdminVolume }, apiSetVolume},
+	{"POST", "/api/pause", func() bool { return dj.conf.Permissions.AdminPause }, apiPause},
+	{"POST", "/api/resume", func() bool { return dj.conf.Permissions.AdminPause }, apiResume},
+	{"GET", "/api/cache", func() bool { return dj.conf.Permissions.AdminCacheSize }, apiGetCache},
+}
+
+// apiGetCurrentSong returns the song that is currently playing or paused, along with the position
+// within it in seconds.
+func apiGetCurrentSong(sender *APISender, r *http.Request) (int, interface{}) {
+	response := apiCurrentSong{
+		Playing: dj.audioStream.IsPlaying(),
+		Paused:  dj.paused,
+	}
+	if dj.HasCurrentSong() {
+		song := NewAPISong(dj.queue.CurrentSong())
+		response.Song = &song
+		response.Elapsed = int(dj.Elapsed().Seconds())
+	}
+	return http.StatusOK, response
+}
+
+// apiGetQueue returns every song in the queue, starting with the current song.
+func apiGetQueue(sender *APISender, r *http.Request) (int, interface{}) {
+	response := apiQueue{Songs: make([]APISong, 0, dj.queue.Len())}
+	dj.queue.Traverse(func(i int, song Song) {
+		response.Songs = append(response.Songs, NewAPISong(song))
+	})
+	return http.StatusOK, response
+}
+
+// apiAdd performs !add with the URL given in the request body, e.g. {"url": "https://youtu.be/..."}.
+func apiAdd(sender *APISender, r *http.Request) (int, interface{}) {
+	var request struct {
+		URL string `json:"url"`
+	}
+	if err := decodeAPIRequest(r, &request); err != nil {
+		return http.StatusBadRequest, apiError{err.Error()}
+	}
+	add(sender, sender.name, request.URL)
+	return http.StatusOK, apiMessages{sender.messages}
+}
+
+// apiSkip performs !skip. The request body may ask for the playlist to be skipped, and for an
+// admin to force the skip, e.g. {"playlist": true, "force": true}. These require the same
+// permissions as !skipplaylist and !forceskip.
+func apiSkip(sender *APISender, r *http.Request) (int, interface{}) {
+	var request struct {
+		Playlist bool `json:"playlist"`
+		Force    bool `json:"force"`
+	}
+	if err := decodeAPIRequest(r, &request); err != nil {
+		return http.StatusBadRequest, apiError{err.Error()}
+	}
+	if (request.Playlist && !dj.HasPermission(sender.name, dj.conf.Permissions.AdminAddPlaylists)) ||
+		(request.Force && !dj.HasPermission(sender.name, true)) {
+		return http.StatusForbidden, apiError{dj.messages.Render(NO_PERMISSION_MSG, MessageData{})}
+	}
+	skip(sender, sender.name, request.Force, request.Playlist)
+	return http.StatusOK, apiMessages{sender.messages}
+}
+
+// apiGetVolume returns the current volume along with the range it may be set within.
+func apiGetVolume(sender *APISender, r *http.Request) (int, interface{}) {
+	return http.StatusOK, apiVolume{
+		Volume:  dj.audioStream.Volume,
+		Lowest:  dj.conf.Volume.LowestVolume,
+		Highest: dj.conf.Volume.HighestVolume,
+	}
+}
+
+// apiSetVolume performs !volume with the volume given in the request body, e.g. {"volume": 0.5}.
+func apiSetVolume(sender *APISender, r *http.Request) (int, interface{}) {
+	var request struct {
+		Volume *float64 `json:"volume"`
+	}
+	if err := decodeAPIRequest(r, &request); err != nil {
+		return http.StatusBadRequest, apiError{err.Error()}
+	}
+	if request.Volume == nil {
+		return http.StatusBadRequest, apiError{dj.messages.Render(NO_ARGUMENT_MSG, MessageData{})}
+	}
+	volume(sender, sender.name, strconv.FormatFloat(*request.Volume, 'f', -1, 32))
+	return http.StatusOK, apiMessages{sender.messages}
+}
+
+// apiPause performs !pause.
+func apiPause(sender *APISender, r *http.Request) (int, interface{}) {
+	pause(sender, sender.name)
+	return http.StatusOK, apiMessages{sender.messages}
+}
+
+// apiResume performs !resume.
+func apiResume(sender *APISender, r *http.Request) (int, interface{}) {
+	resume(sender, sender.name)
+	return http.StatusOK, apiMessages{sender.messages}
+}
+
+// apiGetCache returns the number of songs in the cache and their total size in bytes.
+func apiGetCache(sender *APISender, r *http.Request) (int, interface{}) {
+	response := apiCache{Enabled: dj.conf.Cache.Enabled}
+	if dj.conf.Cache.Enabled {
+		dj.cache.Update()
+		response.Songs = dj.cache.NumSongs
+		response.Size = dj.cache.TotalFileSize
+	}
+	return http.StatusOK, response
+}
+
+// decodeAPIRequest decodes the JSON body of a request into v. An empty body is allowed.
+func decodeAPIRequest(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1048576)).Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("The request body is not valid JSON: %v", err)
+	}
+	return nil
+}
+
+// splitAPIToken splits an entry of the Tokens list in the [HTTP] section into the name used
+// for permission checks and the secret token.
+func splitAPIToken(token string) (name, secret string) {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// apiTokenName returns the name associated with the bearer token in the Authorization header of
+// a request, or an empty string if the token is missing or unknown.
+func apiTokenName(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	given := []byte(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	for _, token := range dj.conf.HTTP.Tokens {
+		if name, secret := splitAPIToken(token); subtle.ConstantTimeCompare(given, []byte(secret)) == 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+// writeAPIResponse writes body to the response as JSON.
+func writeAPIResponse(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.WithError(err).Warn("An error occurred while writing an HTTP API response.")
+	}
+}
+
+// serveAPI finds the endpoint for a request, checks the request's token and the permissions of
+// the name it belongs to, and then calls the endpoint's handler.
+func serveAPI(w http.ResponseWriter, r *http.Request) {
+	var endpoint *apiEndpoint
+	pathFound := false
+	for i := range apiEndpoints {
+		if apiEndpoints[i].Path == r.URL.Path {
+			pathFound = true
+			if apiEndpoints[i].Method == r.Method {
+				endpoint = &apiEndpoints[i]
+			}
+		}
+	}
+	if endpoint == nil {
+		if pathFound {
+			writeAPIResponse(w, http.StatusMethodNotAllowed, apiError{"Method not allowed."})
+		} else {
+			writeAPIResponse(w, http.StatusNotFound, apiError{"Endpoint not found."})
+		}
+		return
+	}
+
+	name := apiTokenName(r)
+	if name == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="MumbleDJ"`)
+		writeAPIResponse(w, http.StatusUnauthorized, apiError{"A valid token must be supplied."})
+		return
+	}
+
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
+	if !dj.connected {
+		writeAPIResponse(w, http.StatusServiceUnavailable, apiError{"MumbleDJ is not connected to a server."})
+	} else if !dj.HasPermission(name, endpoint.Permission()) {
+		writeAPIResponse(w, http.StatusForbidden, apiError{dj.messages.Render(NO_PERMISSION_MSG, MessageData{})})
+	} else {
+		status, body := endpoint.Handler(&APISender{name: name}, r)
+		writeAPIResponse(w, status, body)
+	}
+}
+
+// apiServer is the HTTP server for the API while it is running.
+var apiServer *http.Server
+
+// StartAPIServer starts serving the HTTP API, along with the now playing page if it is enabled, on
+// the address given in the [HTTP] section, unless it is already running. Must be called while
+// commandMutex is held.
+func StartAPIServer() error {
+	if apiServer != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", dj.conf.HTTP.Address)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", serveAPI)
+	if dj.conf.HTTP.WebInterface {
+		mux.HandleFunc("/", serveNowPlayingPage)
+		mux.HandleFunc("/ws", serveWebSocket)
+		webClients.start()
+	}
+	if dj.conf.HTTP.Metrics {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+	apiServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.WithError(err).Error("The HTTP API stopped unexpectedly.")
+		}
+	}(apiServer)
+	logger.WithField("address", listener.Addr().String()).Info("HTTP API listening.")
+	return nil
+}
+
+// StopAPIServer stops serving the HTTP API. Must be called while commandMutex is held.
+func StopAPIServer() {
+	if apiServer != nil {
+		apiServer.Close()
+		apiServer = nil
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * logging.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logger is used for everything MumbleDJ logs. Until the configuration has been loaded, it
+// writes text at the info level to stderr.
+var logger = logrus.New()
+
+// logFile is the log file that is being written to, if any.
+var logFile *lumberjack.Logger
+
+// logLevels maps the values accepted by the Level variable of the [Logging] section to their
+// levels.
+var logLevels = map[string]logrus.Level{
+	"debug": logrus.DebugLevel,
+	"info":  logrus.InfoLevel,
+	"warn":  logrus.WarnLevel,
+	"error": logrus.ErrorLevel,
+}
+
+// ConfigureLogging applies the [Logging] section of conf to logger. When a file is set, it is
+// rotated once it reaches MaxSize megabytes, and the oldest rotated files are removed according
+// to MaxBackups and MaxAge.
+func ConfigureLogging(conf DjConfig) {
+	logger.Level = logLevels[conf.Logging.Level]
+
+	if conf.Logging.Format == "json" {
+		logger.Formatter = &logrus.JSONFormatter{}
+	} else {
+		logger.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	}
+
+	var output io.Writer = os.Stderr
+	if conf.Logging.File != "" {
+		if logFile != nil && logFile.Filename == conf.Logging.File {
+			logFile.MaxSize = conf.Logging.MaxSize
+			logFile.MaxBackups = conf.Logging.MaxBackups
+			logFile.MaxAge = conf.Logging.MaxAge
+		} else {
+			if logFile != nil {
+				logFile.Close()
+			}
+			logFile = &lumberjack.Logger{
+				Filename:   conf.Logging.File,
+				MaxSize:    conf.Logging.MaxSize,
+				MaxBackups: conf.Logging.MaxBackups,
+				MaxAge:     conf.Logging.MaxAge,
+			}
+		}
+		output = logFile
+	} else if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	logger.Out = output
+}
+
+// songFields returns the fields that identify a song in log entries.
+func songFields(s Song) logrus.Fields {
+	fields := logrus.Fields{
+		"song":  s.ID(),
+		"title": s.Title(),
+	}
+	if s.Playlist() != nil {
+		fields["playlist"] = s.Playlist().ID()
+	}
+	return fields
+}
+
+// commandError logs an error that occurred while performing a command, and tells the user who
+// sent the command what failed. MumbleDJ keeps running.
+func commandError(user CommandSender, command, message string, err error) {
+	logger.WithFields(logrus.Fields{
+		"user":    user.Name(),
+		"command": command,
+	}).WithError(err).Error(message)
+	dj.SendPrivateMessage(user, dj.messages.Render(COMMAND_ERROR_MSG, MessageData{Error: message}))
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/layeh/gumble/gumble"
 	"github.com/layeh/gumble/gumble_ffmpeg"
 	"github.com/layeh/gumble/gumbleutil"
+	"github.com/sirupsen/logrus"
 )
 
 // mumbledj is a struct that keeps track of all aspects of the bot's current
@@ -29,56 +30,90 @@ type mumbledj struct {
 	client         *gumble.Client
 	keepAlive      chan bool
 	defaultChannel []string
+	lastChannel    []string
 	conf           DjConfig
+	configFile     string
+	messages       *Messages
 	queue          *SongQueue
 	audioStream    *gumble_ffmpeg.Stream
 	homeDir        string
+	paths          StoragePaths
 	playlistSkips  map[string][]string
 	cache          *SongCache
+	metadata       *MetadataCache
+	events         *EventBus
+	paused         bool
+	songStarted    time.Time
+	songOffset     time.Duration
+	shuttingDown   int32
+	interrupted    bool
+	connected      bool
+	volumeSet      bool
+	playGeneration uint64
 }
 
 // OnConnect event. First moves MumbleDJ into the default channel specified
-// via commandline args, and moves to root channel if the channel does not exist. The current
-// user's homedir path is stored, configuration is loaded, and the audio stream is set up.
+// via commandline args, and moves to root channel if the channel does not exist. After a
+// reconnect, MumbleDJ instead moves back into the channel it was in when it was disconnected if
+// that channel still exists. The audio stream is then set up, keeping the volume and the
+// interrupted song from before a reconnect.
 func (dj *mumbledj) OnConnect(e *gumble.ConnectEvent) {
-	if dj.client.Channels.Find(dj.defaultChannel...) != nil {
+	if dj.lastChannel != nil && dj.client.Channels.Find(dj.lastChannel...) != nil {
+		dj.client.Self.Move(dj.client.Channels.Find(dj.lastChannel...))
+	} else if dj.client.Channels.Find(dj.defaultChannel...) != nil {
 		dj.client.Self.Move(dj.client.Channels.Find(dj.defaultChannel...))
 	} else {
-		fmt.Println("Channel doesn't exist or one was not provided, staying in root channel...")
+		logger.WithField("channel", dj.conf.Connection.Channel).Warn("Channel doesn't exist or one was not provided, staying in root channel.")
 	}
 
+	commandMutex.Lock()
+	volume := dj.conf.Volume.DefaultVolume
+	if dj.audioStream != nil {
+		volume = dj.audioStream.Volume
+	}
 	dj.audioStream = gumble_ffmpeg.New(dj.client)
-	dj.audioStream.Volume = dj.conf.Volume.DefaultVolume
+	dj.audioStream.Volume = volume
+	dj.connected = true
+	dj.resumeSong()
 
 	dj.client.AudioEncoder.SetApplication(gopus.Audio)
 
 	dj.client.Self.SetComment(dj.conf.General.DefaultComment)
 
 	if dj.conf.Cache.Enabled {
+		dj.cache.Load()
 		dj.cache.Update()
-		go dj.cache.ClearExpired()
+		dj.cache.StartExpiry()
+	}
+
+	// The servers are started while commandMutex is held, as a reload may start or stop them.
+	if dj.conf.HTTP.Enabled {
+		if err := StartAPIServer(); err != nil {
+			logger.WithError(err).Error("Could not start the HTTP API.")
+		}
+	}
+	if dj.conf.MPD.Enabled {
+		if err := StartMPDServer(); err != nil {
+			logger.WithError(err).Error("Could not start the MPD server.")
+		}
+	}
+	commandMutex.Unlock()
+
+	if dj.conf.Shutdown.SaveState {
+		go RestoreState()
 	}
 }
 
-// OnDisconnect event. Terminates MumbleDJ thread.
+// OnDisconnect event. Terminates MumbleDJ thread, unless MumbleDJ is shutting down, in which
+// case it exits once shutting down is complete.
 func (dj *mumbledj) OnDisconnect(e *gumble.DisconnectEvent) {
+	if dj.ShuttingDown() {
+		return
+	}
 	if e.Type == gumble.DisconnectError || e.Type == gumble.DisconnectKicked {
-		fmt.Println("Disconnected from server... Will retry connection in 30 second intervals for 15 minutes.")
-		reconnectSuccess := false
-		for retries := 0; retries <= 30; retries++ {
-			fmt.Println("Retrying connection...")
-			if err := dj.client.Connect(); err == nil {
-				fmt.Println("Successfully reconnected to the server!")
-				reconnectSuccess = true
-				break
-			}
-			time.Sleep(30 * time.Second)
-		}
-		if !reconnectSuccess {
-			fmt.Println("Could not reconnect to server. Exiting...")
-			dj.keepAlive <- true
-			os.Exit(1)
-		}
+		logger.WithField("reason", e.String).Warn("Disconnected from server. Trying to reconnect.")
+		dj.interruptSong()
+		go dj.Reconnect()
 	} else {
 		dj.keepAlive <- true
 	}
@@ -90,22 +125,30 @@ func (dj *mumbledj) OnTextMessage(e *gumble.TextMessageEvent) {
 	plainMessage := gumbleutil.PlainText(&e.TextMessage)
 	if len(plainMessage) != 0 {
 		if plainMessage[0] == dj.conf.General.CommandPrefix[0] && plainMessage != dj.conf.General.CommandPrefix {
-			parseCommand(e.Sender, e.Sender.Name, plainMessage[1:])
+			parseCommand(&MumbleSender{e.Sender}, e.Sender.Name, plainMessage[1:])
 		}
 	}
 }
 
 // OnUserChange event. Checks UserChange type, and adjusts items such as skiplists to reflect
-// the current status of the users on the server.
+// the current status of the users on the server. MumbleDJ's own channel is remembered so that it
+// can return there after a reconnect.
 func (dj *mumbledj) OnUserChange(e *gumble.UserChangeEvent) {
+	if e.User == dj.client.Self && e.Type.Has(gumble.UserChangeChannel) {
+		dj.lastChannel = channelPath(e.User.Channel)
+	}
 	if e.Type.Has(gumble.UserChangeDisconnected) {
-		if dj.audioStream.IsPlaying() {
+		if dj.HasCurrentSong() {
 			if dj.queue.CurrentSong().Playlist() != nil {
 				dj.queue.CurrentSong().Playlist().RemoveSkip(e.User.Name)
 			}
 			dj.queue.CurrentSong().RemoveSkip(e.User.Name)
 		}
 	}
+	if e.Type.Has(gumble.UserChangeConnected) || e.Type.Has(gumble.UserChangeDisconnected) || e.Type.Has(gumble.UserChangeChannel) {
+		// The number of skip votes needed depends on the number of users in the channel.
+		NotifyWebClients()
+	}
 }
 
 // HasPermission checks if username has the permissions to execute a command. Permissions are specified in
@@ -122,20 +165,147 @@ func (dj *mumbledj) HasPermission(username string, command bool) bool {
 	return true
 }
 
-// SendPrivateMessage sends a private message to a user. Essentially just checks if a user is still in the server
-// before sending them the message.
-func (dj *mumbledj) SendPrivateMessage(user *gumble.User, message string) {
-	if targetUser := dj.client.Self.Channel.Users.Find(user.Name); targetUser != nil {
-		targetUser.Send(message)
+// SenderHasPermission checks if the sender of a command has the permission needed to perform it.
+// Commands sent through the control socket come from MumbleDJ's host, and are always permitted.
+func (dj *mumbledj) SenderHasPermission(user CommandSender, command bool) bool {
+	if _, local := user.(*CtlSender); local {
+		return true
+	}
+	return dj.HasPermission(user.Name(), command)
+}
+
+// IsInChannel checks if a user is currently in the same channel as MumbleDJ.
+func (dj *mumbledj) IsInChannel(user *gumble.User) bool {
+	return user.Channel != nil && user.Channel == dj.client.Self.Channel
+}
+
+// IsRemoteCommand checks if a command may be issued via private message by a user outside of
+// MumbleDJ's channel. Remote commands are specified in mumbledj.gcfg.
+func (dj *mumbledj) IsRemoteCommand(command string) bool {
+	if dj.conf.Permissions.AllowRemoteCommands {
+		for _, remoteCommand := range dj.conf.Permissions.RemoteCommands {
+			if command == remoteCommand {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SendPrivateMessage sends a private message to the sender of a command.
+func (dj *mumbledj) SendPrivateMessage(user CommandSender, message string) {
+	user.Send(message)
+}
+
+// SendChannelMessage sends a message to MumbleDJ's channel. If the sender of the command that caused the
+// message is not in the channel (i.e. they issued a remote command or used the HTTP API), they are sent a
+// copy of the message privately.
+func (dj *mumbledj) SendChannelMessage(user CommandSender, message string) {
+	dj.client.Self.Channel.Send(message, false)
+	if !user.InChannel() {
+		dj.SendPrivateMessage(user, message)
+	}
+}
+
+// HasCurrentSong checks if a song is currently playing or paused.
+func (dj *mumbledj) HasCurrentSong() bool {
+	return dj.paused || (dj.audioStream != nil && dj.audioStream.IsPlaying())
+}
+
+// Elapsed returns the position within the current song, or 0 if no song is playing.
+func (dj *mumbledj) Elapsed() time.Duration {
+	if dj.paused {
+		return dj.songOffset
+	}
+	if dj.audioStream != nil && dj.audioStream.IsPlaying() {
+		return dj.songOffset + time.Since(dj.songStarted)
+	}
+	return 0
+}
+
+// Pause stops the audio stream and remembers the position within the current song so that it may
+// be resumed later.
+func (dj *mumbledj) Pause() error {
+	offset := dj.Elapsed()
+	dj.paused = true
+	if err := dj.audioStream.Stop(); err != nil {
+		dj.paused = false
+		return err
+	}
+	dj.songOffset = offset
+	return nil
+}
+
+// Resume restarts the audio stream from the position at which the current song was paused.
+func (dj *mumbledj) Resume() error {
+	dj.audioStream.Offset = dj.songOffset
+	if err := dj.audioStream.Play(); err != nil {
+		return err
+	}
+	dj.paused = false
+	dj.songStarted = time.Now()
+	dj.watchSong()
+	return nil
+}
+
+// watchSong moves the queue on once the song that has just started playing finishes. Each song
+// played is numbered, so that waiting for a song that has since been paused and resumed does not
+// move the queue on a second time. Must be called while commandMutex is held.
+func (dj *mumbledj) watchSong() {
+	dj.playGeneration++
+	generation := dj.playGeneration
+	stream := dj.audioStream
+	go func() {
+		stream.Wait()
+		dj.songFinished(generation)
+	}()
+}
+
+// songFinished moves the queue on after the song played as number generation has finished, unless
+// another song has been played since.
+func (dj *mumbledj) songFinished(generation uint64) {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+	if generation == dj.playGeneration {
+		dj.queue.OnSongFinished()
+	}
+}
+
+// StopSong stops the song that is currently playing or paused. The queue then moves on as though the
+// song had finished.
+func (dj *mumbledj) StopSong() error {
+	if dj.paused {
+		dj.paused = false
+		go dj.songFinished(dj.playGeneration)
+		return nil
 	}
+	return dj.audioStream.Stop()
 }
 
 // PerformStartupChecks checks the MumbleDJ installation to ensure proper usage.
 func PerformStartupChecks() {
-	if os.Getenv("YOUTUBE_API_KEY") == "" {
-		fmt.Printf("You do not have a YouTube API key defined in your environment variables.\n" +
-			"Please see the following link for info on how to fix this: https://github.com/matthieugrieger/mumbledj#youtube-api-keys\n")
+	if dj.conf.YouTube.APIKey == "" {
+		if dj.conf.YouTube.Metadata == "api" {
+			logger.Error("You do not have a YouTube API key defined in your configuration or environment variables. " +
+				"Please see the following link for info on how to fix this: https://github.com/matthieugrieger/mumbledj#youtube-api-keys")
+			os.Exit(1)
+		}
+		logger.Info("No YouTube API key is set, so the metadata of videos and playlists will be read with the downloader.")
+	}
+	if version, err := CheckDownloader(dj.conf); err != nil {
+		logger.WithError(err).Error("Songs cannot be downloaded. Install youtube-dl, or set Executable in the [Downloader] section.")
 		os.Exit(1)
+	} else {
+		logger.WithFields(logrus.Fields{
+			"executable": dj.conf.Downloader.Executable,
+			"version":    version,
+		}).Info("Found the downloader.")
+	}
+	if dj.conf.Cache.Format == "opus" {
+		if err := checkOpusEncoder(); err != nil {
+			logger.WithError(err).Error("Songs cannot be stored as Opus. Install an ffmpeg built with libopus, or set Format to \"m4a\" in the [Cache] section.")
+			os.Exit(1)
+		}
 	}
 }
 
@@ -145,62 +315,94 @@ var dj = mumbledj{
 	queue:         NewSongQueue(),
 	playlistSkips: make(map[string][]string),
 	cache:         NewSongCache(),
+	metadata:      NewMetadataCache(),
+	events:        NewEventBus(),
 }
 
 // main primarily performs startup tasks. Grabs and parses commandline
 // args, sets up the gumble client and its listeners, and then connects to the server.
 func main() {
 
-	PerformStartupChecks()
+	var checkConfig bool
 
 	if currentUser, err := user.Current(); err == nil {
 		dj.homeDir = currentUser.HomeDir
 	}
 
-	if err := loadConfiguration(); err == nil {
-		fmt.Println("Configuration successfully loaded!")
-	} else {
-		panic(err)
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtl(os.Args[2:]))
 	}
 
-	var address, port, username, password, channel, pemCert, pemKey, accesstokens string
-	var insecure bool
-
-	flag.StringVar(&address, "server", "localhost", "address for Mumble server")
-	flag.StringVar(&port, "port", "64738", "port for Mumble server")
-	flag.StringVar(&username, "username", "MumbleDJ", "username of MumbleDJ on server")
-	flag.StringVar(&password, "password", "", "password for Mumble server (if needed)")
-	flag.StringVar(&channel, "channel", "root", "default channel for MumbleDJ")
-	flag.StringVar(&pemCert, "cert", "", "path to user PEM certificate for MumbleDJ")
-	flag.StringVar(&pemKey, "key", "", "path to user PEM key for MumbleDJ")
-	flag.StringVar(&accesstokens, "accesstokens", "", "list of access tokens for channel auth")
-	flag.BoolVar(&insecure, "insecure", false, "skip certificate checking")
+	RegisterConfigFlags()
+	flag.StringVar(&dj.configFile, "config", "", "path to the configuration file (default mumbledj.gcfg in the configuration directory)")
+	flag.BoolVar(&checkConfig, "check-config", false, "check the configuration file for errors and exit")
 	flag.Parse()
 
+	if err := loadConfiguration(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if checkConfig {
+		fmt.Printf("%s: Configuration OK.\n", configFilePath())
+		os.Exit(0)
+	}
+	ConfigureLogging(dj.conf)
+	logger.WithField("path", configFilePath()).Info("Configuration successfully loaded!")
+
+	dj.paths = ResolveStoragePaths(dj.conf)
+	if err := dj.paths.CreateStorageDirectories(); err != nil {
+		logger.WithError(err).Error("Could not create the storage directories.")
+		os.Exit(1)
+	}
+	logger.WithFields(logrus.Fields{
+		"songs": dj.paths.Songs,
+		"state": dj.paths.State,
+	}).Debug("Using storage directories.")
+	dj.metadata.Load()
+
+	if dj.conf.General.AutoReload {
+		if err := StartConfigWatcher(); err != nil {
+			logger.WithError(err).Error("Could not watch the configuration file for changes.")
+		}
+	}
+
+	PerformStartupChecks()
+
+	if dj.conf.Control.Enabled {
+		if err := StartControlSocket(); err != nil {
+			logger.WithError(err).Error("Could not open the control socket.")
+		}
+	}
+
+	address := fmt.Sprintf("%s:%d", dj.conf.Connection.Server, dj.conf.Connection.Port)
 	dj.config = gumble.Config{
-		Username: username,
-		Password: password,
-		Address:  address + ":" + port,
-		Tokens:   strings.Split(accesstokens, " "),
+		Username: dj.conf.Connection.Username,
+		Password: dj.conf.Connection.Password,
+		Address:  address,
+		Tokens:   strings.Split(dj.conf.Connection.AccessTokens, " "),
 	}
 	dj.client = gumble.NewClient(&dj.config)
 
 	dj.config.TLSConfig.InsecureSkipVerify = true
-	if !insecure {
-		gumbleutil.CertificateLockFile(dj.client, fmt.Sprintf("%s/.mumbledj/cert.lock", dj.homeDir))
+	if !dj.conf.Connection.Insecure {
+		gumbleutil.CertificateLockFile(dj.client, statePath("cert.lock"))
 	}
-	if pemCert != "" {
+	if pemCert := dj.conf.Connection.Cert; pemCert != "" {
+		pemKey := dj.conf.Connection.Key
 		if pemKey == "" {
 			pemKey = pemCert
 		}
 		if certificate, err := tls.LoadX509KeyPair(pemCert, pemKey); err != nil {
-			panic(err)
+			logger.WithError(err).Error("Could not load the client certificate.")
+			os.Exit(1)
 		} else {
 			dj.config.TLSConfig.Certificates = append(dj.config.TLSConfig.Certificates, certificate)
 		}
 	}
 
-	dj.defaultChannel = strings.Split(channel, "/")
+	dj.defaultChannel = strings.Split(dj.conf.Connection.Channel, "/")
+
+	HandleSignals()
 
 	dj.client.Attach(gumbleutil.Listener{
 		Connect:     dj.OnConnect,
@@ -210,8 +412,14 @@ func main() {
 	})
 	dj.client.Attach(gumbleutil.AutoBitrate)
 
+	dj.events.Attach(ChatAnnouncer)
+	dj.events.Attach(EventLogger)
+	dj.events.Attach(WebhookNotifier)
+	dj.events.Attach(CacheRecorder)
+	RegisterMetrics()
+
 	if err := dj.client.Connect(); err != nil {
-		fmt.Printf("Could not connect to Mumble server at %s:%s.\n", address, port)
+		logger.WithField("address", address).WithError(err).Error("Could not connect to Mumble server.")
 		os.Exit(1)
 	}
 
@@ -0,0 +1,155 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * messages.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// MessageData holds the named fields that may be referenced from within a message
+// template, such as {{.Title}} or {{.Submitter}}. Fields that do not apply to a
+// message are left at their zero values.
+type MessageData struct {
+	Title         string
+	Submitter     string
+	Duration      string
+	Thumbnail     string
+	ID            string
+	Playlist      string
+	User          string
+	Channel       string
+	Volume        float32
+	LowestVolume  float32
+	HighestVolume float32
+	Count         int
+	Size          float64
+	Changes       []string
+	Errors        []string
+	Error         string
+	Reason        string
+	Page          int
+	Pages         int
+	Total         int
+	Failed        int
+	Requests      int
+	CacheHits     int
+	Cached        []CachedSongData
+}
+
+// CachedSongData describes a song in the cache for the cache list message. Size is in MB, and
+// Age is how long ago the song was last used.
+type CachedSongData struct {
+	ID        string
+	Title     string
+	Size      float64
+	Age       string
+	PlayCount int
+	Pinned    bool
+}
+
+// SongMessageData returns MessageData populated with the metadata of a Song.
+func SongMessageData(s Song) MessageData {
+	data := MessageData{
+		Title:     s.Title(),
+		Submitter: s.Submitter(),
+		Duration:  s.Duration(),
+		Thumbnail: s.Thumbnail(),
+		ID:        s.ID(),
+	}
+	if s.Playlist() != nil {
+		data.Playlist = s.Playlist().Title()
+	}
+	return data
+}
+
+// Messages holds the parsed message templates used for all user-facing text.
+type Messages struct {
+	templates *template.Template
+}
+
+// LoadMessages parses the default English messages, followed by the locale bundle in localesDir
+// (if a locale other than "en" is supplied) and the custom message file (if a path
+// is supplied). Messages defined in later files replace those defined earlier.
+func LoadMessages(localesDir, locale, messagesFile string) (*Messages, error) {
+	templates, err := template.New("messages").Parse(DEFAULT_MESSAGES)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	if locale != "" && locale != "en" {
+		files = append(files, filepath.Join(localesDir, locale+".tmpl"))
+	}
+	if messagesFile != "" {
+		files = append(files, messagesFile)
+	}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read message file %s.", file)
+		}
+		if _, err := templates.Parse(string(contents)); err != nil {
+			return nil, fmt.Errorf("Could not parse message file %s: %v", file, err)
+		}
+	}
+
+	// Execute every message once so that typos in field names are reported on load
+	// rather than when the message is first shown.
+	for _, tmpl := range templates.Templates() {
+		if err := tmpl.Execute(ioutil.Discard, MessageData{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Messages{
+		templates: templates,
+	}, nil
+}
+
+// Render executes the message template with the given name using the supplied data. If the
+// template cannot be executed, the error is printed and the name of the message is returned.
+func (m *Messages) Render(name string, data MessageData) string {
+	var message bytes.Buffer
+	if tmpl := m.templates.Lookup(name); tmpl == nil {
+		logger.WithField("message", name).Error("Message is not defined.")
+		return name
+	} else if err := tmpl.Execute(&message, data); err != nil {
+		logger.WithField("message", name).WithError(err).Error("An error occurred while rendering a message.")
+		return name
+	}
+	return message.String()
+}
+
+// messageLineBreaks matches the HTML tags that begin a new line within messages, and messageTags
+// matches every other tag.
+var (
+	messageLineBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</?(p|tr|li)\b[^>]*>`)
+	messageTags       = regexp.MustCompile(`<[^>]*>`)
+)
+
+// PlainText converts a rendered message into plain text for clients that cannot display HTML.
+// Tags are removed, and each line of the message has its whitespace collapsed. Empty lines are
+// left out.
+func PlainText(message string) string {
+	text := messageLineBreaks.ReplaceAllString(message, "\n")
+	text = html.UnescapeString(messageTags.ReplaceAllString(text, ""))
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if fields := strings.Fields(line); len(fields) != 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
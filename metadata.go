@@ -0,0 +1,212 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * metadata.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// MetadataEntry holds the metadata of a video as stored in the metadata cache. Duration is in
+// seconds.
+type MetadataEntry struct {
+	Service   string    `json:"service"`
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Duration  int       `json:"duration"`
+	Thumbnail string    `json:"thumbnail"`
+	Channel   string    `json:"channel"`
+	Fetched   time.Time `json:"fetched"`
+}
+
+// QuotaUsage records the YouTube Data API quota used on Day, a date in Pacific Time, which is
+// when Google resets the quota. CacheHits counts the lookups answered from the metadata cache.
+type QuotaUsage struct {
+	Day       string `json:"day"`
+	Units     int    `json:"units"`
+	Requests  int    `json:"requests"`
+	CacheHits int    `json:"cache_hits"`
+}
+
+// metadataFile is the contents of the metadata cache file.
+type metadataFile struct {
+	Quota   QuotaUsage       `json:"quota"`
+	Entries []*MetadataEntry `json:"entries"`
+}
+
+// MetadataCache keeps the metadata of videos that have been looked up, so that adding the same
+// video again within MetadataTTL hours (set in the [YouTube] section) does not use up YouTube
+// Data API quota. It also records how much quota has been used today. Both are stored in
+// metadata.json in the state directory so that they survive restarts.
+type MetadataCache struct {
+	mutex   sync.Mutex
+	entries map[string]*MetadataEntry
+	quota   QuotaUsage
+}
+
+// NewMetadataCache creates an empty MetadataCache.
+func NewMetadataCache() *MetadataCache {
+	return &MetadataCache{entries: make(map[string]*MetadataEntry)}
+}
+
+// metadataCachePath returns the path of the metadata cache file.
+func metadataCachePath() string {
+	return statePath("metadata.json")
+}
+
+// metadataKey returns the key of the video with the given ID from service.
+func metadataKey(service, id string) string {
+	return service + ":" + id
+}
+
+// quotaLocation is the time zone in which Google resets the YouTube Data API quota.
+var quotaLocation = func() *time.Location {
+	if location, err := time.LoadLocation("America/Los_Angeles"); err == nil {
+		return location
+	}
+	return time.FixedZone("PST", -8*60*60)
+}()
+
+// quotaDay returns the date the quota used at t counts towards.
+func quotaDay(t time.Time) string {
+	return t.In(quotaLocation).Format("2006-01-02")
+}
+
+// Load reads the metadata cache file, leaving out entries that have expired.
+func (m *MetadataCache) Load() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries = make(map[string]*MetadataEntry)
+	contents, err := ioutil.ReadFile(metadataCachePath())
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		logger.WithError(err).Warn("Could not read the metadata cache.")
+		return
+	}
+	var file metadataFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		logger.WithError(err).Warn("The metadata cache is not valid, so it will be rebuilt.")
+		return
+	}
+	m.quota = file.Quota
+	for _, entry := range file.Entries {
+		if !m.expired(entry) {
+			m.entries[metadataKey(entry.Service, entry.ID)] = entry
+		}
+	}
+}
+
+// save writes the metadata cache to disk. Must be called while m.mutex is held.
+func (m *MetadataCache) save() {
+	file := metadataFile{Quota: m.quota, Entries: make([]*MetadataEntry, 0, len(m.entries))}
+	for _, entry := range m.entries {
+		if !m.expired(entry) {
+			file.Entries = append(file.Entries, entry)
+		}
+	}
+	contents, err := json.MarshalIndent(file, "", "\t")
+	if err == nil {
+		path := metadataCachePath()
+		if err = ioutil.WriteFile(path+".tmp", contents, 0600); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Error("An error occurred while saving the metadata cache.")
+	}
+}
+
+// expired returns whether entry is older than MetadataTTL hours.
+func (m *MetadataCache) expired(entry *MetadataEntry) bool {
+	ttl := time.Duration(dj.conf.YouTube.MetadataTTL * float64(time.Hour))
+	return time.Since(entry.Fetched) >= ttl
+}
+
+// Get returns the cached metadata of the video with the given ID from service, if it has not
+// expired.
+func (m *MetadataCache) Get(service, id string) (MetadataEntry, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry, ok := m.entries[metadataKey(service, id)]
+	if !ok || m.expired(entry) {
+		return MetadataEntry{}, false
+	}
+	m.rollQuota()
+	m.quota.CacheHits++
+	return *entry, true
+}
+
+// Put stores the metadata of videos that have just been looked up. Nothing is stored if
+// MetadataTTL is 0.
+func (m *MetadataCache) Put(entries ...MetadataEntry) {
+	if dj.conf.YouTube.MetadataTTL <= 0 || len(entries) == 0 {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, entry := range entries {
+		entry := entry
+		entry.Fetched = time.Now()
+		m.entries[metadataKey(entry.Service, entry.ID)] = &entry
+	}
+	m.save()
+}
+
+// Len returns the number of videos in the metadata cache that have not expired.
+func (m *MetadataCache) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	count := 0
+	for _, entry := range m.entries {
+		if !m.expired(entry) {
+			count++
+		}
+	}
+	return count
+}
+
+// rollQuota starts counting quota afresh once the day it was counted for has passed. Must be
+// called while m.mutex is held.
+func (m *MetadataCache) rollQuota() {
+	if today := quotaDay(time.Now()); m.quota.Day != today {
+		m.quota = QuotaUsage{Day: today}
+	}
+}
+
+// UseQuota records a request to the YouTube Data API that costs the given number of units.
+func (m *MetadataCache) UseQuota(units int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rollQuota()
+	m.quota.Units += units
+	m.quota.Requests++
+	m.save()
+}
+
+// Quota returns the quota used today.
+func (m *MetadataCache) Quota() QuotaUsage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rollQuota()
+	return m.quota
+}
+
+// youtubeQuotaCost returns the number of quota units a request to the given endpoint of the
+// YouTube Data API costs. Searches cost 100 units, and listing videos, playlists and playlist
+// items costs 1.
+func youtubeQuotaCost(endpoint string) int {
+	if endpoint == "search" {
+		return 100
+	}
+	return 1
+}
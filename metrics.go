@@ -0,0 +1,107 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * metrics.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are exposed in the Prometheus text format at /metrics on the HTTP API when the
+// Metrics option in the [HTTP] section is enabled.
+var (
+	songsPlayed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mumbledj_songs_played_total",
+		Help: "Number of songs that have started playing.",
+	})
+	skips = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mumbledj_skips_total",
+		Help: "Number of songs and playlists skipped, by what was skipped and how.",
+	}, []string{"target", "reason"})
+	downloadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mumbledj_download_failures_total",
+		Help: "Number of songs whose audio could not be downloaded, by reason.",
+	}, []string{"reason"})
+	downloadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mumbledj_download_duration_seconds",
+		Help:    "Time taken to download the audio for a song, including failed downloads.",
+		Buckets: []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	})
+	youtubeRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mumbledj_youtube_api_requests_total",
+		Help: "Number of requests made to the YouTube Data API.",
+	})
+	youtubeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mumbledj_youtube_api_errors_total",
+		Help: "Number of failed requests to the YouTube Data API, by reason.",
+	}, []string{"reason"})
+	reconnectAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mumbledj_reconnect_attempts_total",
+		Help: "Number of attempts made to reconnect to the Mumble server after a disconnect.",
+	})
+	queueLength = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mumbledj_queue_length",
+		Help: "Number of songs in the queue, including the current song.",
+	}, func() float64 {
+		commandMutex.Lock()
+		defer commandMutex.Unlock()
+		return float64(dj.queue.Len())
+	})
+	cacheBytes = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mumbledj_cache_bytes",
+		Help: "Total size of the songs in the cache. Always 0 if the cache is disabled.",
+	}, func() float64 {
+		if !dj.conf.Cache.Enabled {
+			return 0
+		}
+		return float64(dj.cache.GetCurrentTotalFileSize())
+	})
+	channelUsers = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mumbledj_channel_users",
+		Help: "Number of users in MumbleDJ's channel, including MumbleDJ itself.",
+	}, func() float64 {
+		if dj.client == nil || dj.client.Self == nil || dj.client.Self.Channel == nil {
+			return 0
+		}
+		return float64(len(dj.client.Self.Channel.Users))
+	})
+)
+
+// skipReason returns the reason label of mumbledj_skips_total for a skip.
+func skipReason(forced, bySubmitter bool) string {
+	if forced {
+		return "forced"
+	} else if bySubmitter {
+		return "submitter"
+	}
+	return "vote"
+}
+
+// MetricsRecorder counts the events that have a metric.
+var MetricsRecorder = Listener{
+	SongStarted: func(e *SongStartedEvent) {
+		songsPlayed.Inc()
+	},
+	SongSkipped: func(e *SongSkippedEvent) {
+		skips.WithLabelValues("song", skipReason(e.Forced, e.BySubmitter)).Inc()
+	},
+	PlaylistSkipped: func(e *PlaylistSkippedEvent) {
+		skips.WithLabelValues("playlist", skipReason(e.Forced, e.BySubmitter)).Inc()
+	},
+	DownloadFailed: func(e *DownloadFailedEvent) {
+		downloadFailures.WithLabelValues(downloadReason(e.Err)).Inc()
+	},
+}
+
+// RegisterMetrics registers MumbleDJ's metrics with Prometheus and attaches MetricsRecorder to
+// dj.events. Metrics are recorded even while /metrics is not being served, so that they are
+// complete if it is enabled later.
+func RegisterMetrics() {
+	prometheus.MustRegister(songsPlayed, skips, downloadFailures, downloadDuration, youtubeRequests,
+		youtubeErrors, reconnectAttempts, queueLength, cacheBytes, channelUsers)
+	dj.events.Attach(MetricsRecorder)
+}
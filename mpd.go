@@ -0,0 +1,737 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * mpd.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MumbleDJ speaks a subset of the protocol used by the Music Player Daemon, so that MPD clients
+// can view and control the queue. The protocol is line based: each command is a line of
+// whitespace-separated arguments, and is answered with "key: value" lines followed by "OK", or
+// by a single "ACK" line describing the error.
+
+// mpdGreeting is sent to every client when it connects.
+const mpdGreeting = "OK MPD 0.19.0"
+
+// Error codes used in ACK responses.
+const (
+	mpdErrorArg        = 2
+	mpdErrorPassword   = 3
+	mpdErrorPermission = 4
+	mpdErrorUnknown    = 5
+	mpdErrorNoExist    = 50
+	mpdErrorSystem     = 52
+)
+
+// mpdAnonymousName is the name used for permission checks when no Passwords are set in the [MPD]
+// section.
+const mpdAnonymousName = "MPD"
+
+// mpdError is an error that is sent to the client in an ACK response.
+type mpdError struct {
+	Code    int
+	Message string
+}
+
+// Error returns the message of the error.
+func (e *mpdError) Error() string {
+	return e.Message
+}
+
+// MPDSender is a CommandSender for commands issued by MPD clients. Messages sent to it are
+// collected so that they may be returned as an error.
+type MPDSender struct {
+	name     string
+	messages []string
+}
+
+// Name returns the name the client authenticated as.
+func (s *MPDSender) Name() string {
+	return s.name
+}
+
+// Send records a message so that it may be returned to the client.
+func (s *MPDSender) Send(message string) {
+	s.messages = append(s.messages, message)
+}
+
+// InChannel always returns true, so that clients only receive the messages that are sent to
+// them privately. For the commands MPD clients may perform, these messages are always errors.
+func (s *MPDSender) InChannel() bool {
+	return true
+}
+
+// err returns the messages sent to the client as an error with the given code, or nil if no
+// messages were sent.
+func (s *MPDSender) err(code int) error {
+	if len(s.messages) == 0 {
+		return nil
+	}
+	message := PlainText(strings.Join(s.messages, "<br>"))
+	return &mpdError{code, strings.Replace(message, "\n", " ", -1)}
+}
+
+// mpdCommand describes a command of the MPD protocol. Permission returns the setting from the
+// [Permissions] section that makes the command admin-only, matching the equivalent chat command,
+// and is nil for commands that may be used before a password is given. Handler is called while
+// commandMutex is held, and writes the response to the client.
+type mpdCommand struct {
+	Name       string
+	Permission func() bool
+	Handler    func(c *mpdConn, args []string) error
+}
+
+// mpdCommands lists every command MumbleDJ understands, apart from idle, noidle, close and the
+// command list commands, which are handled by the connection itself.
+var mpdCommands []mpdCommand
+
+func init() {
+	// mpdCommands refers to mpdListCommands, which refers to mpdCommands, so it must be set here.
+	mpdCommands = []mpdCommand{
+		{"status", func() bool { return dj.conf.Permissions.AdminCurrentSong }, mpdStatus},
+		{"currentsong", func() bool { return dj.conf.Permissions.AdminCurrentSong }, mpdCurrentSong},
+		{"playlistinfo", func() bool { return dj.conf.Permissions.AdminNumSongs }, mpdPlaylistInfo},
+		{"add", func() bool { return dj.conf.Permissions.AdminAdd }, mpdAdd},
+		{"delete", func() bool { return false }, mpdDelete},
+		{"next", func() bool { return dj.conf.Permissions.AdminSkip }, mpdNext},
+		{"pause", func() bool { return dj.conf.Permissions.AdminPause }, mpdPause},
+		{"setvol", func() bool { return dj.conf.Permissions.AdminVolume }, mpdSetVolume},
+		{"ping", nil, mpdPing},
+		{"password", nil, mpdPassword},
+		{"commands", nil, mpdListCommands},
+		{"notcommands", nil, mpdPing},
+		{"tagtypes", nil, mpdTagTypes},
+	}
+}
+
+// mpdStatus writes the state of playback and the queue.
+func mpdStatus(c *mpdConn, args []string) error {
+	ids := c.server.songIDs()
+	c.writeField("volume", mpdVolume(dj.audioStream.Volume))
+	c.writeField("repeat", 0)
+	c.writeField("random", 0)
+	c.writeField("single", 0)
+	// Songs are removed from the queue once they have been played.
+	c.writeField("consume", 1)
+	c.writeField("playlist", c.server.playlistVersion())
+	c.writeField("playlistlength", dj.queue.Len())
+	if !dj.HasCurrentSong() {
+		c.writeField("state", "stop")
+		return nil
+	}
+	if dj.paused {
+		c.writeField("state", "pause")
+	} else {
+		c.writeField("state", "play")
+	}
+	elapsed := dj.Elapsed().Seconds()
+	duration := durationSeconds(dj.queue.CurrentSong().Duration())
+	c.writeField("song", 0)
+	c.writeField("songid", ids[0])
+	c.writeField("time", fmt.Sprintf("%d:%d", int(elapsed), duration))
+	c.writeField("elapsed", fmt.Sprintf("%.3f", elapsed))
+	c.writeField("duration", duration)
+	if dj.queue.Len() > 1 {
+		c.writeField("nextsong", 1)
+		c.writeField("nextsongid", ids[1])
+	}
+	return nil
+}
+
+// mpdCurrentSong writes the song that is currently playing or paused, if any.
+func mpdCurrentSong(c *mpdConn, args []string) error {
+	ids := c.server.songIDs()
+	if dj.HasCurrentSong() {
+		c.writeSong(0, ids[0], dj.queue.CurrentSong())
+	}
+	return nil
+}
+
+// mpdPlaylistInfo writes every song in the queue, starting with the current song, or only the
+// songs at the position or range given.
+func mpdPlaylistInfo(c *mpdConn, args []string) error {
+	ids := c.server.songIDs()
+	start, end := 0, dj.queue.Len()
+	if len(args) > 0 {
+		var err error
+		if start, end, err = mpdRange(args[0]); err != nil {
+			return err
+		}
+	}
+	dj.queue.Traverse(func(i int, song Song) {
+		if i >= start && i < end {
+			c.writeSong(i, ids[i], song)
+		}
+	})
+	return nil
+}
+
+// mpdAdd performs !add with the URL given.
+func mpdAdd(c *mpdConn, args []string) error {
+	if len(args) != 1 {
+		return &mpdError{mpdErrorArg, "wrong number of arguments for \"add\""}
+	}
+	sender := &MPDSender{name: c.name}
+	add(sender, sender.name, args[0])
+	return sender.err(mpdErrorNoExist)
+}
+
+// mpdDelete removes the song at the position or range given from the queue. Admins may remove
+// any song, and other users may only remove the songs they added. Removing the current song
+// skips it.
+func mpdDelete(c *mpdConn, args []string) error {
+	if len(args) != 1 {
+		return &mpdError{mpdErrorArg, "wrong number of arguments for \"delete\""}
+	}
+	start, end, err := mpdRange(args[0])
+	if err != nil {
+		return err
+	}
+	if end > dj.queue.Len() || start >= end {
+		return &mpdError{mpdErrorArg, "Bad song index"}
+	}
+	admin := dj.HasPermission(c.name, true)
+	for i := start; i < end; i++ {
+		if !admin && dj.queue.queue[i].Submitter() != c.name {
+			return &mpdError{mpdErrorPermission, "you may only delete the songs you added"}
+		}
+	}
+
+	sender := &MPDSender{name: c.name}
+	for i := end - 1; i >= start && i > 0; i-- {
+		if song, err := dj.queue.RemoveSong(i); err == nil {
+			dj.events.OnSongRemoved(&SongRemovedEvent{Sender: sender, Song: song})
+		}
+	}
+	if start == 0 {
+		skip(sender, sender.name, admin, false)
+	}
+	return sender.err(mpdErrorSystem)
+}
+
+// mpdNext performs !skip, casting a vote to skip the current song.
+func mpdNext(c *mpdConn, args []string) error {
+	sender := &MPDSender{name: c.name}
+	skip(sender, sender.name, false, false)
+	return sender.err(mpdErrorSystem)
+}
+
+// mpdPause performs !pause when given 1, !resume when given 0, and toggles between the two when
+// given nothing. Pausing while already paused, or resuming while playing, does nothing.
+func mpdPause(c *mpdConn, args []string) error {
+	shouldPause := !dj.paused
+	if len(args) > 0 {
+		switch args[0] {
+		case "0":
+			shouldPause = false
+		case "1":
+			shouldPause = true
+		default:
+			return &mpdError{mpdErrorArg, fmt.Sprintf("Boolean (0/1) expected: %s", args[0])}
+		}
+	}
+	sender := &MPDSender{name: c.name}
+	if shouldPause && !dj.paused {
+		pause(sender, sender.name)
+	} else if !shouldPause && dj.paused {
+		resume(sender, sender.name)
+	}
+	return sender.err(mpdErrorSystem)
+}
+
+// mpdSetVolume performs !volume. MPD volumes go from 0 to 100, which is mapped onto the range
+// allowed by the [Volume] section.
+func mpdSetVolume(c *mpdConn, args []string) error {
+	if len(args) != 1 {
+		return &mpdError{mpdErrorArg, "wrong number of arguments for \"setvol\""}
+	}
+	percent, err := strconv.Atoi(args[0])
+	if err != nil || percent < 0 || percent > 100 {
+		return &mpdError{mpdErrorArg, "Invalid volume value"}
+	}
+	lowest, highest := float64(dj.conf.Volume.LowestVolume), float64(dj.conf.Volume.HighestVolume)
+	value := lowest + float64(percent)/100*(highest-lowest)
+	sender := &MPDSender{name: c.name}
+	volume(sender, sender.name, strconv.FormatFloat(math.Min(math.Max(value, lowest), highest), 'f', 3, 32))
+	return sender.err(mpdErrorArg)
+}
+
+// mpdVolume converts a volume into the range used by MPD, from 0 to 100.
+func mpdVolume(value float32) int {
+	lowest, highest := dj.conf.Volume.LowestVolume, dj.conf.Volume.HighestVolume
+	if highest <= lowest {
+		return 100
+	}
+	return int(math.Floor(float64((value-lowest)/(highest-lowest)*100) + 0.5))
+}
+
+// mpdPing does nothing, and is used by clients to keep the connection open.
+func mpdPing(c *mpdConn, args []string) error {
+	return nil
+}
+
+// mpdPassword authenticates the client with one of the Passwords in the [MPD] section. Commands
+// are performed with the permissions of the Mumble user with the password's name.
+func mpdPassword(c *mpdConn, args []string) error {
+	if len(args) != 1 {
+		return &mpdError{mpdErrorArg, "wrong number of arguments for \"password\""}
+	}
+	for _, password := range dj.conf.MPD.Passwords {
+		if name, secret := splitAPIToken(password); subtle.ConstantTimeCompare([]byte(args[0]), []byte(secret)) == 1 {
+			c.name = name
+			return nil
+		}
+	}
+	return &mpdError{mpdErrorPassword, "incorrect password"}
+}
+
+// mpdListCommands writes the commands the client may use.
+func mpdListCommands(c *mpdConn, args []string) error {
+	for _, command := range mpdCommands {
+		if c.allowed(command) {
+			c.writeField("command", command.Name)
+		}
+	}
+	for _, name := range []string{"close", "command_list_begin", "command_list_ok_begin", "command_list_end", "idle", "noidle"} {
+		c.writeField("command", name)
+	}
+	return nil
+}
+
+// mpdTagTypes writes the tags that are included with each song.
+func mpdTagTypes(c *mpdConn, args []string) error {
+	c.writeField("tagtype", "Artist")
+	c.writeField("tagtype", "Album")
+	c.writeField("tagtype", "Title")
+	return nil
+}
+
+// mpdRange parses a song position ("1") or range ("1:3", or "1:" for every song from position 1
+// onwards) into the position of the first song and the position after the last.
+func mpdRange(value string) (start, end int, err error) {
+	parts := strings.SplitN(value, ":", 2)
+	if start, err = strconv.Atoi(parts[0]); err != nil || start < 0 {
+		return 0, 0, &mpdError{mpdErrorArg, fmt.Sprintf("Integer or range expected: %s", value)}
+	}
+	if len(parts) == 1 {
+		return start, start + 1, nil
+	}
+	if parts[1] == "" {
+		return start, dj.queue.Len(), nil
+	}
+	if end, err = strconv.Atoi(parts[1]); err != nil || end < start {
+		return 0, 0, &mpdError{mpdErrorArg, fmt.Sprintf("Integer or range expected: %s", value)}
+	}
+	return start, end, nil
+}
+
+// durationSeconds converts a duration in the form returned by Song.Duration (such as "3:27" or
+// "1:02:03") into seconds.
+func durationSeconds(duration string) int {
+	multipliers := []int{1, 60, 3600, 86400}
+	parts := strings.Split(duration, ":")
+	seconds := 0
+	for i := 0; i < len(parts) && i < len(multipliers); i++ {
+		value, _ := strconv.Atoi(parts[len(parts)-1-i])
+		seconds += value * multipliers[i]
+	}
+	return seconds
+}
+
+// splitMPDArguments splits a command line into its arguments. Arguments are separated by
+// whitespace, and may be surrounded with double quotes, within which \" and \\ are escapes.
+func splitMPDArguments(line string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		var arg []byte
+		if line[i] == '"' {
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				arg = append(arg, line[i])
+			}
+			if i >= len(line) {
+				return nil, &mpdError{mpdErrorArg, "Missing closing '\"'"}
+			}
+			i++
+		} else {
+			for ; i < len(line) && line[i] != ' ' && line[i] != '\t'; i++ {
+				arg = append(arg, line[i])
+			}
+		}
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+// MPDServer accepts MPD clients and keeps track of the state they are shown.
+type MPDServer struct {
+	listener net.Listener
+	detacher Detacher
+
+	mutex   sync.Mutex
+	conns   map[*mpdConn]bool
+	version int
+
+	// ids holds the song ID given to each song in the queue. It is only used while commandMutex
+	// is held.
+	ids    map[Song]int
+	nextID int
+}
+
+// mpdServer is the MPD server while it is running.
+var mpdServer *MPDServer
+
+// StartMPDServer starts accepting MPD clients on the address given in the [MPD] section, unless
+// this is already being done. Must be called while commandMutex is held.
+func StartMPDServer() error {
+	if mpdServer != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", dj.conf.MPD.Address)
+	if err != nil {
+		return err
+	}
+	server := &MPDServer{
+		listener: listener,
+		conns:    make(map[*mpdConn]bool),
+		version:  1,
+		ids:      make(map[Song]int),
+	}
+	playlistChanged := func() { server.changed("playlist") }
+	playerChanged := func() { server.changed("player") }
+	server.detacher = dj.events.Attach(Listener{
+		SongQueued:      func(e *SongQueuedEvent) { playlistChanged() },
+		PlaylistQueued:  func(e *PlaylistQueuedEvent) { playlistChanged() },
+		SongRemoved:     func(e *SongRemovedEvent) { playlistChanged() },
+		SongStarted:     func(e *SongStartedEvent) { server.changed("player", "playlist") },
+		SongFinished:    func(e *SongFinishedEvent) { server.changed("player", "playlist") },
+		PlaylistSkipped: func(e *PlaylistSkippedEvent) { playlistChanged() },
+		PlaybackPaused:  func(e *PlaybackPausedEvent) { playerChanged() },
+		PlaybackResumed: func(e *PlaybackResumedEvent) { playerChanged() },
+		VolumeChanged:   func(e *VolumeChangedEvent) { server.changed("mixer") },
+		QueueReset:      func(e *QueueResetEvent) { server.changed("player", "playlist") },
+	})
+	mpdServer = server
+	go server.serve()
+	logger.WithField("address", listener.Addr().String()).Info("MPD server listening.")
+	return nil
+}
+
+// StopMPDServer stops accepting MPD clients and disconnects those that are connected. Must be
+// called while commandMutex is held.
+func StopMPDServer() {
+	if mpdServer == nil {
+		return
+	}
+	mpdServer.detacher.Detach()
+	mpdServer.listener.Close()
+	mpdServer.mutex.Lock()
+	for c := range mpdServer.conns {
+		c.conn.Close()
+	}
+	mpdServer.mutex.Unlock()
+	mpdServer = nil
+}
+
+// serve accepts clients until the server is stopped.
+func (s *MPDServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &mpdConn{
+			server:  s,
+			conn:    conn,
+			writer:  bufio.NewWriter(conn),
+			changes: make(map[string]bool),
+			wake:    make(chan bool, 1),
+		}
+		if len(dj.conf.MPD.Passwords) == 0 {
+			c.name = mpdAnonymousName
+		}
+		s.mutex.Lock()
+		s.conns[c] = true
+		s.mutex.Unlock()
+		go c.serve()
+	}
+}
+
+// changed records that the given subsystems have changed, so that idle clients are told about
+// them. A change to the playlist subsystem also changes the playlist version.
+func (s *MPDServer) changed(subsystems ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, subsystem := range subsystems {
+		if subsystem == "playlist" {
+			s.version++
+		}
+	}
+	for c := range s.conns {
+		for _, subsystem := range subsystems {
+			c.changes[subsystem] = true
+		}
+		select {
+		case c.wake <- true:
+		default:
+		}
+	}
+}
+
+// playlistVersion returns a number that changes whenever the queue changes.
+func (s *MPDServer) playlistVersion() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.version
+}
+
+// songIDs returns the song ID of each song in the queue, in order. A song keeps the same ID for
+// as long as it is in the queue.
+func (s *MPDServer) songIDs() []int {
+	ids := make([]int, 0, dj.queue.Len())
+	current := make(map[Song]int)
+	dj.queue.Traverse(func(i int, song Song) {
+		id, exists := s.ids[song]
+		if !exists {
+			s.nextID++
+			id = s.nextID
+		}
+		current[song] = id
+		ids = append(ids, id)
+	})
+	s.ids = current
+	return ids
+}
+
+// mpdConn is a connected MPD client. name is empty until the client has given a password.
+type mpdConn struct {
+	server  *MPDServer
+	conn    net.Conn
+	writer  *bufio.Writer
+	name    string
+	changes map[string]bool
+	wake    chan bool
+}
+
+// serve reads and performs the client's commands until it disconnects.
+func (c *mpdConn) serve() {
+	defer func() {
+		c.server.mutex.Lock()
+		delete(c.server.conns, c)
+		c.server.mutex.Unlock()
+		c.conn.Close()
+	}()
+
+	lines := make(chan string)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(c.conn)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	c.writeLine(mpdGreeting)
+	c.writer.Flush()
+
+	var list []string
+	inList, listOK := false, false
+	for line := range lines {
+		switch {
+		case line == "command_list_begin" || line == "command_list_ok_begin":
+			list, inList, listOK = nil, true, line == "command_list_ok_begin"
+		case inList && line == "command_list_end":
+			for i, command := range list {
+				if !c.perform(command, i) {
+					break
+				}
+				if listOK {
+					c.writeLine("list_OK")
+				}
+				if i == len(list)-1 {
+					c.writeLine("OK")
+				}
+			}
+			if len(list) == 0 {
+				c.writeLine("OK")
+			}
+			inList = false
+		case inList:
+			list = append(list, line)
+		case line == "close":
+			return
+		case line == "noidle":
+			// noidle is only meaningful while idle, and is otherwise ignored.
+		case line == "idle" || strings.HasPrefix(line, "idle "):
+			if args, err := splitMPDArguments(line); err != nil {
+				c.writeError(err, 0, "idle")
+			} else if !c.idle(args[1:], lines) {
+				return
+			}
+		default:
+			if c.perform(line, 0) {
+				c.writeLine("OK")
+			}
+		}
+		if err := c.writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// perform performs a single command, and returns whether it succeeded. If it failed, an ACK
+// response has been written. index is the position of the command within a command list.
+func (c *mpdConn) perform(line string, index int) bool {
+	args, err := splitMPDArguments(line)
+	if err != nil {
+		c.writeError(err, index, "")
+		return false
+	}
+	if len(args) == 0 {
+		c.writeError(&mpdError{mpdErrorUnknown, "No command given"}, index, "")
+		return false
+	}
+
+	var command *mpdCommand
+	for i := range mpdCommands {
+		if mpdCommands[i].Name == args[0] {
+			command = &mpdCommands[i]
+		}
+	}
+	if command == nil {
+		c.writeError(&mpdError{mpdErrorUnknown, fmt.Sprintf("unknown command \"%s\"", args[0])}, index, "")
+		return false
+	}
+
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
+	if !c.allowed(*command) {
+		err = &mpdError{mpdErrorPermission, fmt.Sprintf("you don't have permission for \"%s\"", command.Name)}
+	} else if !dj.connected {
+		err = &mpdError{mpdErrorSystem, "MumbleDJ is not connected to a server"}
+	} else {
+		err = command.Handler(c, args[1:])
+	}
+	if err != nil {
+		c.writeError(err, index, command.Name)
+		return false
+	}
+	return true
+}
+
+// allowed returns whether the client may use a command.
+func (c *mpdConn) allowed(command mpdCommand) bool {
+	return command.Permission == nil || (c.name != "" && dj.HasPermission(c.name, command.Permission()))
+}
+
+// idle waits until one of the given subsystems (or any subsystem, if none are given) changes,
+// or until the client sends noidle, and then writes the subsystems that changed. It returns
+// false if the client disconnected or sent another command, in which case the connection is
+// closed as it is by MPD.
+func (c *mpdConn) idle(subsystems []string, lines chan string) bool {
+	for {
+		if changed := c.takeChanges(subsystems); len(changed) > 0 {
+			for _, subsystem := range changed {
+				c.writeField("changed", subsystem)
+			}
+			c.writeLine("OK")
+			return true
+		}
+		if err := c.writer.Flush(); err != nil {
+			return false
+		}
+		select {
+		case <-c.wake:
+		case line, ok := <-lines:
+			if !ok || line != "noidle" {
+				return false
+			}
+			for _, subsystem := range c.takeChanges(subsystems) {
+				c.writeField("changed", subsystem)
+			}
+			c.writeLine("OK")
+			return true
+		}
+	}
+}
+
+// takeChanges returns the given subsystems (or every subsystem, if none are given) that have
+// changed since they were last returned.
+func (c *mpdConn) takeChanges(subsystems []string) []string {
+	c.server.mutex.Lock()
+	defer c.server.mutex.Unlock()
+	var changed []string
+	for _, subsystem := range []string{"player", "playlist", "mixer"} {
+		wanted := len(subsystems) == 0
+		for _, name := range subsystems {
+			wanted = wanted || name == subsystem
+		}
+		if wanted && c.changes[subsystem] {
+			changed = append(changed, subsystem)
+			delete(c.changes, subsystem)
+		}
+	}
+	return changed
+}
+
+// writeLine writes a line of the response.
+func (c *mpdConn) writeLine(line string) {
+	c.writer.WriteString(line + "\n")
+}
+
+// writeField writes a "key: value" line of the response. Line breaks within the value are
+// replaced, as they would end the line early.
+func (c *mpdConn) writeField(key string, value interface{}) {
+	c.writeLine(fmt.Sprintf("%s: %s", key, strings.Replace(fmt.Sprint(value), "\n", " ", -1)))
+}
+
+// writeSong writes the fields describing a song. Artist is the user that added the song, and
+// Album is the playlist the song is from, if any.
+func (c *mpdConn) writeSong(position, id int, song Song) {
+	c.writeField("file", fmt.Sprintf("https://youtu.be/%s", song.ID()))
+	c.writeField("Title", song.Title())
+	c.writeField("Artist", song.Submitter())
+	if song.Playlist() != nil {
+		c.writeField("Album", song.Playlist().Title())
+	}
+	c.writeField("Time", durationSeconds(song.Duration()))
+	c.writeField("duration", durationSeconds(song.Duration()))
+	c.writeField("Pos", position)
+	c.writeField("Id", id)
+}
+
+// writeError writes an ACK response for a failed command.
+func (c *mpdConn) writeError(err error, index int, command string) {
+	code := mpdErrorSystem
+	if e, ok := err.(*mpdError); ok {
+		code = e.Code
+	}
+	c.writeLine(fmt.Sprintf("ACK [%d@%d] {%s} %s", code, index, command, err.Error()))
+}
@@ -8,25 +8,73 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"code.google.com/p/gcfg"
 )
 
 // DjConfig is a Golang struct representation of mumbledj.gcfg file structure for parsing.
 type DjConfig struct {
+	Connection struct {
+		Server       string
+		Port         int
+		Username     string
+		Password     string
+		Channel      string
+		Cert         string
+		Key          string
+		AccessTokens string
+		Insecure     bool
+	}
 	General struct {
 		CommandPrefix     string
 		SkipRatio         float32
 		PlaylistSkipRatio float32
 		DefaultComment    string
 		MaxSongDuration   int
+		Locale            string
+		MessagesFile      string
+		AutoReload        bool
+	}
+	Storage struct {
+		DataDirectory  string
+		CacheDirectory string
+		StateDirectory string
+	}
+	YouTube struct {
+		APIKey      string
+		APIBaseURL  string
+		APITimeout  int
+		Metadata    string
+		MetadataTTL float64
+		DailyQuota  int
 	}
 	Cache struct {
 		Enabled     bool
 		MaximumSize int64
 		ExpireTime  float64
+		Format      string
+		Bitrate     int
+	}
+	Downloader struct {
+		Executable      string
+		Arguments       []string
+		Formats         []string
+		Proxy           string
+		CookiesFile     string
+		Workers         int
+		Timeout         int
+		MetadataTimeout int
+		Retries         int
+		RetryDelay      int
 	}
 	Volume struct {
 		DefaultVolume float32
@@ -41,6 +89,8 @@ type DjConfig struct {
 		AdminSkipPlaylistAlias string
 		HelpAlias              string
 		VolumeAlias            string
+		PauseAlias             string
+		ResumeAlias            string
 		MoveAlias              string
 		ReloadAlias            string
 		ResetAlias             string
@@ -50,34 +100,623 @@ type DjConfig struct {
 		SetCommentAlias        string
 		NumCachedAlias         string
 		CacheSizeAlias         string
+		CacheAlias             string
+		QuotaAlias             string
 		KillAlias              string
 	}
 	Permissions struct {
-		AdminsEnabled     bool
-		Admins            []string
-		AdminAdd          bool
-		AdminAddPlaylists bool
-		AdminSkip         bool
-		AdminHelp         bool
-		AdminVolume       bool
-		AdminMove         bool
-		AdminReload       bool
-		AdminReset        bool
-		AdminNumSongs     bool
-		AdminNextSong     bool
-		AdminCurrentSong  bool
-		AdminSetComment   bool
-		AdminNumCached    bool
-		AdminCacheSize    bool
-		AdminKill         bool
+		AdminsEnabled       bool
+		Admins              []string
+		AdminAdd            bool
+		AdminAddPlaylists   bool
+		AdminSkip           bool
+		AdminHelp           bool
+		AdminVolume         bool
+		AdminPause          bool
+		AdminMove           bool
+		AdminReload         bool
+		AdminReset          bool
+		AdminNumSongs       bool
+		AdminNextSong       bool
+		AdminCurrentSong    bool
+		AdminSetComment     bool
+		AdminNumCached      bool
+		AdminCacheSize      bool
+		AdminCache          bool
+		AdminQuota          bool
+		AdminKill           bool
+		AllowRemoteCommands bool
+		RemoteCommands      []string
+	}
+	HTTP struct {
+		Enabled      bool
+		Address      string
+		Tokens       []string
+		WebInterface bool
+		Metrics      bool
+	}
+	Control struct {
+		Enabled bool
+		Socket  string
+	}
+	MPD struct {
+		Enabled   bool
+		Address   string
+		Passwords []string
+	}
+	Webhooks struct {
+		URLs    []string
+		Events  []string
+		Secret  string
+		Timeout int
+		Retries int
+	}
+	Reconnect struct {
+		InitialDelay int
+		MaxDelay     int
+		Jitter       float64
+		MaxAttempts  int
+	}
+	Shutdown struct {
+		Timeout     int
+		DeleteSongs bool
+		SaveState   bool
+		StateFile   string
+	}
+	Logging struct {
+		Level      string
+		Format     string
+		File       string
+		MaxSize    int
+		MaxBackups int
+		MaxAge     int
+	}
+}
+
+// ConfigError describes a problem with the configuration file. Line is 0 if the problem
+// does not relate to a specific line of the file (for example, an invalid default value).
+type ConfigError struct {
+	File    string
+	Line    int
+	Field   string
+	Message string
+}
+
+// Error returns the ConfigError formatted as "file:line: Section.Field: message".
+func (e ConfigError) Error() string {
+	location := e.File
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d", e.File, e.Line)
+	}
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", location, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, e.Field, e.Message)
+}
+
+// ConfigErrors holds every problem found while loading the configuration file.
+type ConfigErrors []ConfigError
+
+// Error returns all of the ConfigErrors, one per line.
+func (e ConfigErrors) Error() string {
+	messages := []string{"Configuration load failed."}
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// defaultConfiguration returns a DjConfig holding the built-in default value of every field.
+// Values read from mumbledj.gcfg are applied on top of these defaults, so fields missing from
+// the file (for example, after upgrading MumbleDJ) keep a sensible value.
+func defaultConfiguration() DjConfig {
+	var conf DjConfig
+
+	conf.Connection.Server = "localhost"
+	conf.Connection.Port = 64738
+	conf.Connection.Username = "MumbleDJ"
+	conf.Connection.Password = ""
+	conf.Connection.Channel = "root"
+	conf.Connection.Cert = ""
+	conf.Connection.Key = ""
+	conf.Connection.AccessTokens = ""
+	conf.Connection.Insecure = false
+
+	conf.General.CommandPrefix = "!"
+	conf.General.SkipRatio = 0.5
+	conf.General.PlaylistSkipRatio = 0.5
+	conf.General.DefaultComment = "Hello! I am a bot. Type !help for a list of commands."
+	conf.General.MaxSongDuration = 0
+	conf.General.Locale = "en"
+	conf.General.MessagesFile = ""
+	conf.General.AutoReload = true
+
+	conf.Storage.DataDirectory = ""
+	conf.Storage.CacheDirectory = ""
+	conf.Storage.StateDirectory = ""
+
+	conf.YouTube.APIKey = ""
+	conf.YouTube.APIBaseURL = "https://www.googleapis.com/youtube/v3"
+	conf.YouTube.APITimeout = 10
+	conf.YouTube.Metadata = "auto"
+	conf.YouTube.MetadataTTL = 168
+	conf.YouTube.DailyQuota = 10000
+
+	conf.Cache.Enabled = false
+	conf.Cache.MaximumSize = 512
+	conf.Cache.ExpireTime = 24
+	conf.Cache.Format = "m4a"
+	conf.Cache.Bitrate = 64
+
+	conf.Downloader.Executable = "youtube-dl"
+	conf.Downloader.Proxy = ""
+	conf.Downloader.CookiesFile = ""
+	conf.Downloader.Workers = 2
+	conf.Downloader.Timeout = 300
+	conf.Downloader.MetadataTimeout = 30
+	conf.Downloader.Retries = 2
+	conf.Downloader.RetryDelay = 5
+
+	conf.Volume.DefaultVolume = 0.2
+	conf.Volume.LowestVolume = 0.01
+	conf.Volume.HighestVolume = 0.8
+
+	conf.Aliases.AddAlias = "add"
+	conf.Aliases.SkipAlias = "skip"
+	conf.Aliases.SkipPlaylistAlias = "skipplaylist"
+	conf.Aliases.AdminSkipAlias = "forceskip"
+	conf.Aliases.AdminSkipPlaylistAlias = "forceskipplaylist"
+	conf.Aliases.HelpAlias = "help"
+	conf.Aliases.VolumeAlias = "volume"
+	conf.Aliases.PauseAlias = "pause"
+	conf.Aliases.ResumeAlias = "resume"
+	conf.Aliases.MoveAlias = "move"
+	conf.Aliases.ReloadAlias = "reload"
+	conf.Aliases.ResetAlias = "reset"
+	conf.Aliases.NumSongsAlias = "numsongs"
+	conf.Aliases.NextSongAlias = "nextsong"
+	conf.Aliases.CurrentSongAlias = "currentsong"
+	conf.Aliases.SetCommentAlias = "setcomment"
+	conf.Aliases.NumCachedAlias = "numcached"
+	conf.Aliases.CacheSizeAlias = "cachesize"
+	conf.Aliases.CacheAlias = "cache"
+	conf.Aliases.QuotaAlias = "quota"
+	conf.Aliases.KillAlias = "kill"
+
+	conf.Permissions.AdminsEnabled = true
+	conf.Permissions.AdminAdd = false
+	conf.Permissions.AdminAddPlaylists = false
+	conf.Permissions.AdminSkip = false
+	conf.Permissions.AdminHelp = false
+	conf.Permissions.AdminVolume = false
+	conf.Permissions.AdminPause = false
+	conf.Permissions.AdminMove = true
+	conf.Permissions.AdminReload = true
+	conf.Permissions.AdminReset = true
+	conf.Permissions.AdminNumSongs = false
+	conf.Permissions.AdminNextSong = false
+	conf.Permissions.AdminCurrentSong = false
+	conf.Permissions.AdminSetComment = true
+	conf.Permissions.AdminNumCached = true
+	conf.Permissions.AdminCacheSize = true
+	conf.Permissions.AdminCache = true
+	conf.Permissions.AdminQuota = true
+	conf.Permissions.AdminKill = true
+	conf.Permissions.AllowRemoteCommands = true
+
+	conf.HTTP.Enabled = false
+	conf.HTTP.Address = "127.0.0.1:8080"
+	conf.HTTP.WebInterface = true
+	conf.HTTP.Metrics = false
+
+	conf.Control.Enabled = true
+	conf.Control.Socket = ""
+
+	conf.MPD.Enabled = false
+	conf.MPD.Address = "127.0.0.1:6600"
+
+	conf.Webhooks.Timeout = 10
+	conf.Webhooks.Retries = 3
+
+	conf.Reconnect.InitialDelay = 5
+	conf.Reconnect.MaxDelay = 300
+	conf.Reconnect.Jitter = 0.2
+	conf.Reconnect.MaxAttempts = 0
+
+	conf.Shutdown.Timeout = 10
+	conf.Shutdown.DeleteSongs = false
+	conf.Shutdown.SaveState = true
+	conf.Shutdown.StateFile = ""
+
+	conf.Logging.Level = "info"
+	conf.Logging.Format = "text"
+	conf.Logging.File = ""
+	conf.Logging.MaxSize = 10
+	conf.Logging.MaxBackups = 5
+	conf.Logging.MaxAge = 28
+
+	return conf
+}
+
+// applyListDefaults sets the default value of multi-valued fields that were not present in the
+// configuration file. These cannot be set in defaultConfiguration, as gcfg appends to lists
+// rather than replacing them.
+func applyListDefaults(conf *DjConfig) {
+	if conf.Permissions.RemoteCommands == nil {
+		conf.Permissions.RemoteCommands = []string{"help", "add", "numsongs", "nextsong", "currentsong"}
+	}
+	if conf.Webhooks.Events == nil {
+		conf.Webhooks.Events = []string{"song_started", "playlist_queued"}
+	}
+	if conf.Downloader.Formats == nil {
+		conf.Downloader.Formats = []string{"m4a", "bestaudio"}
+	}
+}
+
+// configFilePath returns the path of the configuration file. The path may be set with the
+// -config flag or the MUMBLEDJ_CONFIG environment variable, and is
+// mumbledj.gcfg in the configuration directory otherwise.
+func configFilePath() string {
+	if dj.configFile != "" {
+		return dj.configFile
+	}
+	if path := os.Getenv("MUMBLEDJ_CONFIG"); path != "" {
+		return path
+	}
+	return defaultConfigFilePath()
+}
+
+// defaultConfigFilePath returns the path of the configuration file when no other path is given.
+func defaultConfigFilePath() string {
+	return filepath.Join(defaultConfigDirectory(), "mumbledj.gcfg")
+}
+
+// readConfiguration reads the configuration file at path on top of the built-in defaults,
+// applies environment variable and command-line overrides, and validates the result. All
+// problems found are returned as ConfigErrors. If the default configuration file does not
+// exist, MumbleDJ runs using the defaults and overrides alone.
+func readConfiguration(path string) (DjConfig, error) {
+	conf := defaultConfiguration()
+	if _, err := os.Stat(path); os.IsNotExist(err) && path == defaultConfigFilePath() {
+		logger.WithField("path", path).Info("No configuration file found, using default values.")
+	} else if err := gcfg.ReadFileInto(&conf, path); err != nil {
+		return conf, gcfgErrors(path, err)
 	}
+	applyListDefaults(&conf)
+	if errs := applyOverrides(&conf); len(errs) != 0 {
+		return conf, errs
+	}
+	if errs := validateConfiguration(path, &conf); len(errs) != 0 {
+		return conf, errs
+	}
+	return conf, nil
 }
 
-// Loads mumbledj.gcfg into dj.conf, a variable of type DjConfig.
+// Loads mumbledj.gcfg into dj.conf, a variable of type DjConfig. The message templates
+// selected in the configuration are loaded into dj.messages.
 func loadConfiguration() error {
-	if gcfg.ReadFileInto(&dj.conf, fmt.Sprintf("%s/.mumbledj/config/mumbledj.gcfg", dj.homeDir)) == nil {
-		return nil
+	newConfig, messages, err := readConfigurationAndMessages()
+	if err != nil {
+		return err
+	}
+	dj.conf = newConfig
+	dj.messages = messages
+	return nil
+}
+
+// readConfigurationAndMessages reads the configuration file along with the message templates
+// it selects, without applying either.
+func readConfigurationAndMessages() (DjConfig, *Messages, error) {
+	path := configFilePath()
+	newConfig, err := readConfiguration(path)
+	if err != nil {
+		return newConfig, nil, err
+	}
+	messages, err := LoadMessages(ResolveStoragePaths(newConfig).Locales, newConfig.General.Locale, newConfig.General.MessagesFile)
+	if err != nil {
+		field := "Locale"
+		if newConfig.General.MessagesFile != "" {
+			field = "MessagesFile"
+		}
+		return newConfig, nil, ConfigErrors{configError(path, "General", field, err.Error())}
+	}
+	return newConfig, messages, nil
+}
+
+// configError creates a ConfigError for a field, looking up the line on which the field is set
+// or the environment variable or command-line flag that overrides it.
+func configError(path, section, variable, message string) ConfigError {
+	if override := configOverride(section, variable); override != "" {
+		return ConfigError{
+			File:    override,
+			Field:   section + "." + variable,
+			Message: message,
+		}
+	}
+	return ConfigError{
+		File:    path,
+		Line:    configLine(path, section, variable),
+		Field:   section + "." + variable,
+		Message: message,
+	}
+}
+
+// validateConfiguration checks that every field of the configuration holds a usable value.
+func validateConfiguration(path string, conf *DjConfig) ConfigErrors {
+	var errs ConfigErrors
+	invalid := func(section, variable, format string, args ...interface{}) {
+		errs = append(errs, configError(path, section, variable, fmt.Sprintf(format, args...)))
+	}
+
+	if conf.Connection.Server == "" {
+		invalid("Connection", "Server", "A server address must be provided.")
+	}
+	if conf.Connection.Port < 1 || conf.Connection.Port > 65535 {
+		invalid("Connection", "Port", "The port must be between 1 and 65535.")
+	}
+	if conf.Connection.Username == "" {
+		invalid("Connection", "Username", "A username must be provided.")
+	}
+
+	if len(conf.General.CommandPrefix) != 1 {
+		invalid("General", "CommandPrefix", "The command prefix must be a single character.")
+	}
+	if conf.General.SkipRatio < 0 || conf.General.SkipRatio > 1 {
+		invalid("General", "SkipRatio", "The skip ratio must be between 0 and 1.")
+	}
+	if conf.General.PlaylistSkipRatio < 0 || conf.General.PlaylistSkipRatio > 1 {
+		invalid("General", "PlaylistSkipRatio", "The playlist skip ratio must be between 0 and 1.")
+	}
+	if conf.General.MaxSongDuration < 0 {
+		invalid("General", "MaxSongDuration", "The maximum song duration must not be negative.")
+	}
+	if conf.General.Locale == "" {
+		invalid("General", "Locale", "A locale must be provided.")
+	}
+
+	if dir := conf.Storage.DataDirectory; dir != "" && !filepath.IsAbs(expandHome(dir)) {
+		invalid("Storage", "DataDirectory", "The data directory must be an absolute path.")
+	}
+	if dir := conf.Storage.CacheDirectory; dir != "" && !filepath.IsAbs(expandHome(dir)) {
+		invalid("Storage", "CacheDirectory", "The cache directory must be an absolute path.")
+	}
+	if dir := conf.Storage.StateDirectory; dir != "" && !filepath.IsAbs(expandHome(dir)) {
+		invalid("Storage", "StateDirectory", "The state directory must be an absolute path.")
+	}
+
+	if parsed, err := url.Parse(conf.YouTube.APIBaseURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		invalid("YouTube", "APIBaseURL", "The API base URL must be an http or https URL.")
+	}
+	if conf.YouTube.APITimeout <= 0 {
+		invalid("YouTube", "APITimeout", "The API timeout must be greater than 0.")
+	}
+	if conf.YouTube.Metadata != "auto" && conf.YouTube.Metadata != "api" && conf.YouTube.Metadata != "downloader" {
+		invalid("YouTube", "Metadata", "The metadata source must be \"auto\", \"api\" or \"downloader\".")
+	}
+	if conf.YouTube.MetadataTTL < 0 {
+		invalid("YouTube", "MetadataTTL", "The metadata cache time must not be negative.")
+	}
+	if conf.YouTube.DailyQuota <= 0 {
+		invalid("YouTube", "DailyQuota", "The daily quota must be greater than 0.")
+	}
+
+	if conf.Cache.MaximumSize <= 0 {
+		invalid("Cache", "MaximumSize", "The maximum cache size must be greater than 0.")
+	}
+	if conf.Cache.ExpireTime <= 0 {
+		invalid("Cache", "ExpireTime", "The cache expire time must be greater than 0.")
+	}
+	if conf.Cache.Format != "m4a" && conf.Cache.Format != "opus" {
+		invalid("Cache", "Format", "The format must be \"m4a\" or \"opus\".")
+	}
+	if conf.Cache.Bitrate < 6 || conf.Cache.Bitrate > 510 {
+		invalid("Cache", "Bitrate", "The bitrate must be between 6 and 510.")
+	}
+
+	if conf.Downloader.Executable == "" {
+		invalid("Downloader", "Executable", "A downloader executable must be provided.")
+	}
+	if len(conf.Downloader.Formats) == 0 {
+		invalid("Downloader", "Formats", "At least one format must be provided.")
+	}
+	if conf.Downloader.CookiesFile != "" {
+		if _, err := os.Stat(conf.Downloader.CookiesFile); err != nil {
+			invalid("Downloader", "CookiesFile", "The cookies file could not be read.")
+		}
+	}
+	if conf.Downloader.Workers < 1 {
+		invalid("Downloader", "Workers", "At least one worker must be allowed.")
+	}
+	if conf.Downloader.Timeout <= 0 {
+		invalid("Downloader", "Timeout", "The download timeout must be greater than 0.")
+	}
+	if conf.Downloader.MetadataTimeout <= 0 {
+		invalid("Downloader", "MetadataTimeout", "The metadata timeout must be greater than 0.")
+	}
+	if conf.Downloader.Retries < 0 {
+		invalid("Downloader", "Retries", "The number of retries must not be negative.")
+	}
+	if conf.Downloader.RetryDelay < 0 {
+		invalid("Downloader", "RetryDelay", "The retry delay must not be negative.")
+	}
+
+	if conf.Volume.LowestVolume < 0 {
+		invalid("Volume", "LowestVolume", "The lowest volume must not be negative.")
+	}
+	if conf.Volume.LowestVolume > conf.Volume.HighestVolume {
+		invalid("Volume", "HighestVolume", "The highest volume must not be lower than LowestVolume (%g).", conf.Volume.LowestVolume)
+	}
+	if conf.Volume.DefaultVolume < conf.Volume.LowestVolume || conf.Volume.DefaultVolume > conf.Volume.HighestVolume {
+		invalid("Volume", "DefaultVolume", "The default volume must be between LowestVolume (%g) and HighestVolume (%g).",
+			conf.Volume.LowestVolume, conf.Volume.HighestVolume)
+	}
+
+	aliases := reflect.ValueOf(conf.Aliases)
+	usedAliases := make(map[string]string)
+	for i := 0; i < aliases.NumField(); i++ {
+		name := aliases.Type().Field(i).Name
+		alias := aliases.Field(i).String()
+		if alias == "" {
+			invalid("Aliases", name, "The alias must not be empty.")
+		} else if strings.ContainsAny(alias, " \t\n") {
+			invalid("Aliases", name, "The alias %q must not contain whitespace.", alias)
+		} else if otherName, exists := usedAliases[alias]; exists {
+			// Report the duplicate against the alias that was changed in the file, if any.
+			if configLine(path, "Aliases", name) == 0 && configLine(path, "Aliases", otherName) != 0 {
+				name, otherName = otherName, name
+			}
+			invalid("Aliases", name, "The alias %q is already used by %s.", alias, otherName)
+		} else {
+			usedAliases[alias] = name
+		}
+	}
+
+	for _, command := range conf.Permissions.RemoteCommands {
+		if _, exists := usedAliases[command]; !exists {
+			invalid("Permissions", "RemoteCommands", "%q is not the alias of a command.", command)
+		}
+	}
+
+	if conf.HTTP.Enabled && conf.HTTP.Address == "" {
+		invalid("HTTP", "Address", "An address must be provided when the HTTP API is enabled.")
+	}
+	usedTokens := make(map[string]bool)
+	for _, token := range conf.HTTP.Tokens {
+		if name, secret := splitAPIToken(token); name == "" || secret == "" {
+			invalid("HTTP", "Tokens", "Tokens must be given in the form \"name:token\".")
+		} else if usedTokens[secret] {
+			invalid("HTTP", "Tokens", "The token for %s is already used by another name.", name)
+		} else {
+			usedTokens[secret] = true
+		}
+	}
+
+	if conf.MPD.Enabled && conf.MPD.Address == "" {
+		invalid("MPD", "Address", "An address must be provided when the MPD server is enabled.")
+	}
+	usedPasswords := make(map[string]bool)
+	for _, password := range conf.MPD.Passwords {
+		if name, secret := splitAPIToken(password); name == "" || secret == "" {
+			invalid("MPD", "Passwords", "Passwords must be given in the form \"name:password\".")
+		} else if usedPasswords[secret] {
+			invalid("MPD", "Passwords", "The password for %s is already used by another name.", name)
+		} else {
+			usedPasswords[secret] = true
+		}
+	}
+
+	for _, webhookURL := range conf.Webhooks.URLs {
+		if parsed, err := url.Parse(webhookURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			invalid("Webhooks", "URLs", "%q is not a valid http or https URL.", webhookURL)
+		}
+	}
+	for _, event := range conf.Webhooks.Events {
+		known := false
+		for _, webhookEvent := range webhookEvents {
+			known = known || event == webhookEvent
+		}
+		if !known {
+			invalid("Webhooks", "Events", "%q is not an event. The events are: %s.", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	if conf.Webhooks.Timeout <= 0 {
+		invalid("Webhooks", "Timeout", "The webhook timeout must be greater than 0.")
+	}
+	if conf.Webhooks.Retries < 0 {
+		invalid("Webhooks", "Retries", "The number of webhook retries must not be negative.")
+	}
+
+	if conf.Reconnect.InitialDelay <= 0 {
+		invalid("Reconnect", "InitialDelay", "The initial reconnect delay must be greater than 0.")
+	}
+	if conf.Reconnect.MaxDelay < conf.Reconnect.InitialDelay {
+		invalid("Reconnect", "MaxDelay", "The maximum reconnect delay must not be less than the initial delay (%d).", conf.Reconnect.InitialDelay)
+	}
+	if conf.Reconnect.Jitter < 0 || conf.Reconnect.Jitter > 1 {
+		invalid("Reconnect", "Jitter", "The reconnect jitter must be between 0 and 1.")
+	}
+	if conf.Reconnect.MaxAttempts < 0 {
+		invalid("Reconnect", "MaxAttempts", "The maximum number of reconnect attempts must not be negative.")
+	}
+
+	if conf.Shutdown.Timeout <= 0 {
+		invalid("Shutdown", "Timeout", "The shutdown timeout must be greater than 0.")
+	}
+
+	if _, ok := logLevels[conf.Logging.Level]; !ok {
+		invalid("Logging", "Level", "%q is not a log level. The levels are: debug, info, warn, error.", conf.Logging.Level)
+	}
+	if conf.Logging.Format != "text" && conf.Logging.Format != "json" {
+		invalid("Logging", "Format", "%q is not a log format. The formats are: text, json.", conf.Logging.Format)
+	}
+	if conf.Logging.MaxSize <= 0 {
+		invalid("Logging", "MaxSize", "The maximum log file size must be greater than 0.")
+	}
+	if conf.Logging.MaxBackups < 0 {
+		invalid("Logging", "MaxBackups", "The number of old log files to keep must not be negative.")
+	}
+	if conf.Logging.MaxAge < 0 {
+		invalid("Logging", "MaxAge", "The maximum age of old log files must not be negative.")
+	}
+
+	return errs
+}
+
+// configLine returns the line of the configuration file on which a variable is set, or the
+// line on which the section begins if variable is empty. 0 is returned if it cannot be found.
+func configLine(path, section, variable string) int {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	currentSection := ""
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			currentSection = strings.TrimSpace(strings.Trim(line, "[]"))
+			if variable == "" && strings.EqualFold(currentSection, section) {
+				return i + 1
+			}
+		} else if variable != "" && strings.EqualFold(currentSection, section) {
+			name := strings.TrimSpace(strings.SplitN(line, "=", 2)[0])
+			if strings.EqualFold(name, variable) {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+var (
+	gcfgVariableExp = regexp.MustCompile(`section "([^"]*)",? variable "([^"]*)"`)
+	gcfgSectionExp  = regexp.MustCompile(`section "([^"]*)"`)
+)
+
+// gcfgErrors converts an error returned by gcfg into ConfigErrors, adding the field and line
+// number of each problem where gcfg does not include them.
+func gcfgErrors(path string, err error) ConfigErrors {
+	var errs ConfigErrors
+	positionExp := regexp.MustCompile(`^` + regexp.QuoteMeta(path) + `:(\d+):\d+: (.*)$`)
+	seen := make(map[string]bool)
+	for _, message := range strings.Split(err.Error(), "\n") {
+		message = strings.TrimSpace(message)
+		if message == "" || strings.HasPrefix(message, "warning") || seen[message] {
+			continue
+		}
+		seen[message] = true
+
+		configErr := ConfigError{File: path, Message: message}
+		if match := positionExp.FindStringSubmatch(message); match != nil {
+			configErr.Line, _ = strconv.Atoi(match[1])
+			configErr.Message = match[2]
+		} else if match := gcfgVariableExp.FindStringSubmatch(message); match != nil {
+			configErr.Line = configLine(path, match[1], match[2])
+			configErr.Field = match[1] + "." + match[2]
+		} else if match := gcfgSectionExp.FindStringSubmatch(message); match != nil {
+			configErr.Line = configLine(path, match[1], "")
+			configErr.Field = match[1]
+		}
+		errs = append(errs, configErr)
 	}
-	fmt.Printf("%s/.mumbledj/config/mumbledj.gcfg\n", dj.homeDir)
-	return errors.New("Configuration load failed.")
+	return errs
 }
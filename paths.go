@@ -0,0 +1,126 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * paths.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MumbleDJ keeps its files in one of three layouts, chosen in the following order:
+//
+//   1. The DataDirectory set in the [Storage] section (or with MUMBLEDJ_STORAGE_DATADIRECTORY or
+//      -storage.datadirectory), holding config/, songs/ and locales/ along with the state files.
+//   2. ~/.mumbledj, laid out the same way, if it exists.
+//   3. The XDG base directories: the configuration file in $XDG_CONFIG_HOME/mumbledj, songs in
+//      $XDG_CACHE_HOME/mumbledj/songs, state files in $XDG_STATE_HOME/mumbledj and locale bundles
+//      in $XDG_DATA_HOME/mumbledj/locales.
+//
+// CacheDirectory and StateDirectory in the [Storage] section move the songs and the state files
+// elsewhere whichever layout is used.
+
+// StoragePaths holds the directories in which MumbleDJ keeps its files.
+type StoragePaths struct {
+	Songs   string
+	State   string
+	Locales string
+}
+
+// legacyDirectory returns ~/.mumbledj, where MumbleDJ has always kept its files.
+func legacyDirectory() string {
+	return filepath.Join(dj.homeDir, ".mumbledj")
+}
+
+// xdgDirectory returns the mumbledj directory within the base directory named by the given XDG
+// environment variable, or within fallback (relative to the home directory) if it is not set.
+func xdgDirectory(variable, fallback string) string {
+	if base := os.Getenv(variable); filepath.IsAbs(base) {
+		return filepath.Join(base, "mumbledj")
+	}
+	return filepath.Join(dj.homeDir, fallback, "mumbledj")
+}
+
+// expandHome replaces a leading ~ in path with the home directory.
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(dj.homeDir, path[1:])
+	}
+	return path
+}
+
+// storageRoot returns the directory holding all of MumbleDJ's files given the data directory
+// set in the configuration, or "" if the XDG base directories are used.
+func storageRoot(dataDirectory string) string {
+	if dataDirectory != "" {
+		return expandHome(dataDirectory)
+	}
+	if info, err := os.Stat(legacyDirectory()); err == nil && info.IsDir() {
+		return legacyDirectory()
+	}
+	return ""
+}
+
+// ResolveStoragePaths returns the directories that MumbleDJ uses with conf.
+func ResolveStoragePaths(conf DjConfig) StoragePaths {
+	var paths StoragePaths
+	if root := storageRoot(conf.Storage.DataDirectory); root != "" {
+		paths = StoragePaths{
+			Songs:   filepath.Join(root, "songs"),
+			State:   root,
+			Locales: filepath.Join(root, "locales"),
+		}
+	} else {
+		paths = StoragePaths{
+			Songs:   filepath.Join(xdgDirectory("XDG_CACHE_HOME", ".cache"), "songs"),
+			State:   xdgDirectory("XDG_STATE_HOME", filepath.Join(".local", "state")),
+			Locales: filepath.Join(xdgDirectory("XDG_DATA_HOME", filepath.Join(".local", "share")), "locales"),
+		}
+	}
+	if conf.Storage.CacheDirectory != "" {
+		paths.Songs = expandHome(conf.Storage.CacheDirectory)
+	}
+	if conf.Storage.StateDirectory != "" {
+		paths.State = expandHome(conf.Storage.StateDirectory)
+	}
+	return paths
+}
+
+// CreateStorageDirectories creates the songs and state directories if they do not exist.
+func (paths StoragePaths) CreateStorageDirectories() error {
+	for _, dir := range []string{paths.Songs, paths.State} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultConfigDirectory returns the directory holding the configuration file when no path is
+// given for it. The data directory may be set in the environment or on the command line, but not
+// in the configuration file itself.
+func defaultConfigDirectory() string {
+	dataDirectory := os.Getenv("MUMBLEDJ_STORAGE_DATADIRECTORY")
+	if value, ok := configFlags["Storage.DataDirectory"].(*configFlag); ok && len(value.values) > 0 {
+		dataDirectory = value.values[len(value.values)-1]
+	}
+	if root := storageRoot(dataDirectory); root != "" {
+		return filepath.Join(root, "config")
+	}
+	return xdgDirectory("XDG_CONFIG_HOME", ".config")
+}
+
+// songPath returns the path of the song stored in filename.
+func songPath(filename string) string {
+	return filepath.Join(dj.paths.Songs, filename)
+}
+
+// statePath returns the path of the state file called name, such as cache.json.
+func statePath(name string) string {
+	return filepath.Join(dj.paths.State, name)
+}
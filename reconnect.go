@@ -0,0 +1,112 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * reconnect.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/layeh/gumble/gumble"
+	"github.com/layeh/gumble/gumble_ffmpeg"
+	"github.com/sirupsen/logrus"
+)
+
+// reconnectRandom picks the jitter added to each wait between reconnect attempts.
+var reconnectRandom = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// reconnectDelay returns how long to wait before the given reconnect attempt, counting from 1.
+// The wait starts at InitialDelay and doubles after each attempt up to MaxDelay, and is then
+// moved up or down by a random amount of up to Jitter times itself.
+func reconnectDelay(conf DjConfig, attempt int) time.Duration {
+	delay := time.Duration(conf.Reconnect.InitialDelay) * time.Second
+	maxDelay := time.Duration(conf.Reconnect.MaxDelay) * time.Second
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	jitter := conf.Reconnect.Jitter * (2*reconnectRandom.Float64() - 1)
+	return time.Duration(float64(delay) * (1 + jitter))
+}
+
+// Reconnect tries to connect to the server again after MumbleDJ has been disconnected, following
+// the policy in the [Reconnect] section. MumbleDJ exits if every attempt fails. Reconnect is run
+// in its own goroutine so that it does not hold up gumble's events.
+func (dj *mumbledj) Reconnect() {
+	maxAttempts := dj.conf.Reconnect.MaxAttempts
+	for attempt := 1; maxAttempts == 0 || attempt <= maxAttempts; attempt++ {
+		wait := reconnectDelay(dj.conf, attempt)
+		logger.WithFields(logrus.Fields{
+			"attempt": attempt,
+			"wait":    wait.String(),
+		}).Info("Waiting before trying to reconnect.")
+		time.Sleep(wait)
+		if dj.ShuttingDown() {
+			return
+		}
+
+		reconnectAttempts.Inc()
+		if err := dj.client.Connect(); err != nil {
+			logger.WithField("attempt", attempt).WithError(err).Warn("Could not reconnect to the server.")
+			continue
+		}
+		logger.WithField("attempt", attempt).Info("Successfully reconnected to the server!")
+		return
+	}
+	logger.WithField("attempts", maxAttempts).Error("Could not reconnect to server. Exiting.")
+	os.Exit(1)
+}
+
+// interruptSong stops the current song when MumbleDJ is disconnected, remembering the position
+// it had reached so that resumeSong can continue it once MumbleDJ has reconnected. Commands that
+// need a connection are refused until then.
+func (dj *mumbledj) interruptSong() {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+	dj.connected = false
+	if dj.audioStream != nil && dj.audioStream.IsPlaying() && !dj.paused {
+		if err := dj.Pause(); err != nil {
+			logger.WithError(err).Error("An error occurred while stopping the audio stream.")
+			return
+		}
+		dj.interrupted = true
+		logger.WithFields(songFields(dj.queue.CurrentSong())).WithField("position", dj.songOffset.String()).Info("Interrupted the current song.")
+	}
+}
+
+// resumeSong sets up the audio stream created after reconnecting with the song that was playing
+// or paused when MumbleDJ was disconnected, and continues playing it if it was interrupted. Must
+// be called while commandMutex is held.
+func (dj *mumbledj) resumeSong() {
+	if !dj.paused || dj.queue.Len() == 0 {
+		return
+	}
+	song := dj.queue.CurrentSong()
+	dj.audioStream.Source = gumble_ffmpeg.SourceFile(songPath(song.Filename()))
+	if !dj.interrupted {
+		return
+	}
+	dj.interrupted = false
+	if err := dj.Resume(); err != nil {
+		logger.WithFields(songFields(song)).WithError(err).Error("An error occurred while resuming the song.")
+		return
+	}
+	logger.WithFields(songFields(song)).WithField("position", dj.songOffset.String()).Info("Resumed the interrupted song.")
+}
+
+// channelPath returns the names of the channels leading from the root channel to channel, in the
+// form accepted by Channels.Find.
+func channelPath(channel *gumble.Channel) []string {
+	var names []string
+	for ; channel != nil && channel.Parent != nil; channel = channel.Parent {
+		names = append([]string{channel.Name}, names...)
+	}
+	return names
+}
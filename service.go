@@ -16,9 +16,12 @@ type Song interface {
 	AddSkip(string) error
 	RemoveSkip(string) error
 	SkipReached(int) bool
+	Skips() int
 	Submitter() string
+	Service() string
 	Title() string
 	ID() string
+	Offset() int
 	Filename() string
 	Duration() string
 	Thumbnail() string
@@ -34,6 +37,7 @@ type Playlist interface {
 	RemoveSkip(string) error
 	DeleteSkippers()
 	SkipReached(int) bool
+	Skips() int
 	ID() string
 	Title() string
 }
@@ -8,11 +8,11 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"regexp"
@@ -20,7 +20,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/jmoiron/jsonq"
 	"github.com/layeh/gumble/gumble_ffmpeg"
 )
 
@@ -28,6 +27,10 @@ import (
 // YOUTUBE SONG
 // ------------
 
+// ErrSongTooLong is returned when a song is longer than the MaxSongDuration set in the [General]
+// section.
+var ErrSongTooLong = errors.New("Song exceeds the maximum allowed duration.")
+
 // YouTubeSong holds the metadata for a song extracted from a YouTube video.
 type YouTubeSong struct {
 	submitter string
@@ -45,11 +48,8 @@ type YouTubeSong struct {
 // NewYouTubeSong gathers the metadata for a song extracted from a YouTube video, and returns
 // the song.
 func NewYouTubeSong(user, id, offset string, playlist *YouTubePlaylist) (*YouTubeSong, error) {
-	var apiResponse *jsonq.JsonQuery
-	var err error
-	url := fmt.Sprintf("https://www.googleapis.com/youtube/v3/videos?part=snippet,contentDetails&id=%s&key=%s",
-		id, os.Getenv("YOUTUBE_API_KEY"))
-	if apiResponse, err = PerformGetRequest(url); err != nil {
+	video, err := FetchYouTubeVideo(id)
+	if err != nil {
 		return nil, err
 	}
 
@@ -78,54 +78,15 @@ func NewYouTubeSong(user, id, offset string, playlist *YouTubePlaylist) (*YouTub
 		}
 	}
 
-	title, _ := apiResponse.String("items", "0", "snippet", "title")
-	thumbnail, _ := apiResponse.String("items", "0", "snippet", "thumbnails", "high", "url")
-	duration, _ := apiResponse.String("items", "0", "contentDetails", "duration")
-
-	var days, hours, minutes, seconds int64
-	timestampExp := regexp.MustCompile(`P(?P<days>\d+D)?T(?P<hours>\d+H)?(?P<minutes>\d+M)?(?P<seconds>\d+S)?`)
-	timestampMatch := timestampExp.FindStringSubmatch(duration)
-	timestampResult := make(map[string]string)
-	for i, name := range timestampExp.SubexpNames() {
-		if i < len(timestampMatch) {
-			timestampResult[name] = timestampMatch[i]
-		}
-	}
-
-	if timestampResult["days"] != "" {
-		days, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["days"], "D"), 10, 32)
-	}
-	if timestampResult["hours"] != "" {
-		hours, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["hours"], "H"), 10, 32)
-	}
-	if timestampResult["minutes"] != "" {
-		minutes, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["minutes"], "M"), 10, 32)
-	}
-	if timestampResult["seconds"] != "" {
-		seconds, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["seconds"], "S"), 10, 32)
-	}
-
-	totalSeconds := int((days * 86400) + (hours * 3600) + (minutes * 60) + seconds)
-	var durationString string
-	if hours != 0 {
-		if days != 0 {
-			durationString = fmt.Sprintf("%d:%02d:%02d:%02d", days, hours, minutes, seconds)
-		} else {
-			durationString = fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
-		}
-	} else {
-		durationString = fmt.Sprintf("%d:%02d", minutes, seconds)
-	}
-
-	if dj.conf.General.MaxSongDuration == 0 || totalSeconds <= dj.conf.General.MaxSongDuration {
+	if dj.conf.General.MaxSongDuration == 0 || video.Duration <= dj.conf.General.MaxSongDuration {
 		song := &YouTubeSong{
 			submitter: user,
-			title:     title,
+			title:     video.Title,
 			id:        id,
 			offset:    int((offsetDays * 86400) + (offsetHours * 3600) + (offsetMinutes * 60) + offsetSeconds),
-			filename:  id + ".m4a",
-			duration:  durationString,
-			thumbnail: thumbnail,
+			filename:  songFilename(id),
+			duration:  formatSongDuration(video.Duration),
+			thumbnail: video.Thumbnail,
 			skippers:  make([]string, 0),
 			playlist:  nil,
 			dontSkip:  false,
@@ -133,23 +94,16 @@ func NewYouTubeSong(user, id, offset string, playlist *YouTubePlaylist) (*YouTub
 		dj.queue.AddSong(song)
 		return song, nil
 	}
-	return nil, errors.New("Song exceeds the maximum allowed duration.")
+	return nil, ErrSongTooLong
 }
 
-// Download downloads the song via youtube-dl if it does not already exist on disk.
-// All downloaded songs are stored in ~/.mumbledj/songs and should be automatically cleaned.
+// Download downloads the song via the downloader set in the [Downloader] section if it does not
+// already exist on disk, through the download manager. All downloaded songs are stored in the
+// songs directory and should be automatically cleaned.
 func (s *YouTubeSong) Download() error {
-	if _, err := os.Stat(fmt.Sprintf("%s/.mumbledj/songs/%s", dj.homeDir, s.Filename())); os.IsNotExist(err) {
-		cmd := exec.Command("youtube-dl", "--no-mtime", "--output", fmt.Sprintf(`~/.mumbledj/songs/%s`, s.Filename()), "--format", "m4a", "--", s.ID())
-		if err := cmd.Run(); err == nil {
-			if dj.conf.Cache.Enabled {
-				dj.cache.CheckMaximumDirectorySize()
-			}
-			return nil
-		}
-		return errors.New("Song download failed.")
-	}
-	return nil
+	return downloadManager.Download(s, func(ctx context.Context) *exec.Cmd {
+		return downloadCommand(ctx, s.ID(), downloadFilename(s.Filename()))
+	})
 }
 
 // Play plays the song. Once the song is playing, a notification is displayed in a text message that features the video
@@ -159,57 +113,23 @@ func (s *YouTubeSong) Play() {
 		offsetDuration, _ := time.ParseDuration(fmt.Sprintf("%ds", s.offset))
 		dj.audioStream.Offset = offsetDuration
 	}
-	dj.audioStream.Source = gumble_ffmpeg.SourceFile(fmt.Sprintf("%s/.mumbledj/songs/%s", dj.homeDir, s.Filename()))
+	dj.audioStream.Source = gumble_ffmpeg.SourceFile(songPath(s.Filename()))
 	if err := dj.audioStream.Play(); err != nil {
-		panic(err)
+		logger.WithFields(songFields(s)).WithError(err).Error("An error occurred while playing the song.")
+		announce(nil, dj.messages.Render(COMMAND_ERROR_MSG, MessageData{Error: fmt.Sprintf("An error occurred while playing \"%s\".", s.Title())}))
+		dj.queue.OnSongFinished()
 	} else {
-		if s.Playlist() == nil {
-			message := `
-				<table>
-					<tr>
-						<td align="center"><img src="%s" width=150 /></td>
-					</tr>
-					<tr>
-						<td align="center"><b><a href="http://youtu.be/%s">%s</a> (%s)</b></td>
-					</tr>
-					<tr>
-						<td align="center">Added by %s</td>
-					</tr>
-				</table>
-			`
-			dj.client.Self.Channel.Send(fmt.Sprintf(message, s.Thumbnail(), s.ID(), s.Title(),
-				s.Duration(), s.Submitter()), false)
-		} else {
-			message := `
-				<table>
-					<tr>
-						<td align="center"><img src="%s" width=150 /></td>
-					</tr>
-					<tr>
-						<td align="center"><b><a href="http://youtu.be/%s">%s</a> (%s)</b></td>
-					</tr>
-					<tr>
-						<td align="center">Added by %s</td>
-					</tr>
-					<tr>
-						<td align="center">From playlist "%s"</td>
-					</tr>
-				</table>
-			`
-			dj.client.Self.Channel.Send(fmt.Sprintf(message, s.Thumbnail(), s.ID(),
-				s.Title(), s.Duration(), s.Submitter(), s.Playlist().Title()), false)
-		}
-		go func() {
-			dj.audioStream.Wait()
-			dj.queue.OnSongFinished()
-		}()
+		dj.songOffset = dj.audioStream.Offset
+		dj.songStarted = time.Now()
+		dj.events.OnSongStarted(&SongStartedEvent{Song: s})
+		dj.watchSong()
 	}
 }
 
-// Delete deletes the song from ~/.mumbledj/songs if the cache is disabled.
+// Delete deletes the song from the songs directory if the cache is disabled.
 func (s *YouTubeSong) Delete() error {
 	if dj.conf.Cache.Enabled == false {
-		filePath := fmt.Sprintf("%s/.mumbledj/songs/%s.m4a", dj.homeDir, s.ID())
+		filePath := songPath(s.Filename())
 		if _, err := os.Stat(filePath); err == nil {
 			if err := os.Remove(filePath); err == nil {
 				return nil
@@ -255,11 +175,21 @@ func (s *YouTubeSong) SkipReached(channelUsers int) bool {
 	return false
 }
 
+// Skips returns the number of users that have voted to skip the YouTubeSong.
+func (s *YouTubeSong) Skips() int {
+	return len(s.skippers)
+}
+
 // Submitter returns the name of the submitter of the YouTubeSong.
 func (s *YouTubeSong) Submitter() string {
 	return s.submitter
 }
 
+// Service returns the name of the service the YouTubeSong comes from.
+func (s *YouTubeSong) Service() string {
+	return "YouTube"
+}
+
 // Title returns the title of the YouTubeSong.
 func (s *YouTubeSong) Title() string {
 	return s.title
@@ -270,6 +200,11 @@ func (s *YouTubeSong) ID() string {
 	return s.id
 }
 
+// Offset returns the position in seconds from which the YouTubeSong starts playing.
+func (s *YouTubeSong) Offset() int {
+	return s.offset
+}
+
 // Filename returns the filename of the YouTubeSong.
 func (s *YouTubeSong) Filename() string {
 	return s.filename
@@ -310,101 +245,45 @@ type YouTubePlaylist struct {
 	title string
 }
 
-// NewYouTubePlaylist gathers the metadata for a YouTube playlist and returns it.
-func NewYouTubePlaylist(user, id string) (*YouTubePlaylist, error) {
-	var apiResponse *jsonq.JsonQuery
-	var err error
-	// Retrieve title of playlist
-	url := fmt.Sprintf("https://www.googleapis.com/youtube/v3/playlists?part=snippet&id=%s&key=%s",
-		id, os.Getenv("YOUTUBE_API_KEY"))
-	if apiResponse, err = PerformGetRequest(url); err != nil {
-		return nil, err
+// FetchYouTubePlaylist gathers the metadata for a YouTube playlist and the songs within it, without
+// adding them to the queue. Songs longer than the maximum allowed duration are left out.
+func FetchYouTubePlaylist(user, id string) (*YouTubePlaylist, []*YouTubeSong, error) {
+	var (
+		title  string
+		videos []youtubeVideo
+		err    error
+	)
+	if youtubeMetadataFromDownloader() {
+		title, videos, err = fetchYouTubePlaylistWithDownloader(id)
+	} else {
+		title, videos, err = fetchYouTubePlaylistFromAPI(id)
+	}
+	if err != nil {
+		return nil, nil, err
 	}
-	title, _ := apiResponse.String("items", "0", "snippet", "title")
 
 	playlist := &YouTubePlaylist{
 		id:    id,
 		title: title,
 	}
-
-	// Retrieve items in playlist
-	url = fmt.Sprintf("https://www.googleapis.com/youtube/v3/playlistItems?part=snippet&maxResults=25&playlistId=%s&key=%s",
-		id, os.Getenv("YOUTUBE_API_KEY"))
-	if apiResponse, err = PerformGetRequest(url); err != nil {
-		return nil, err
-	}
-	numVideos, _ := apiResponse.Int("pageInfo", "totalResults")
-	if numVideos > 25 {
-		numVideos = 25
-	}
-
-	for i := 0; i < numVideos; i++ {
-		index := strconv.Itoa(i)
-		videoTitle, err := apiResponse.String("items", index, "snippet", "title")
-		videoID, _ := apiResponse.String("items", index, "snippet", "resourceId", "videoId")
-		videoThumbnail, _ := apiResponse.String("items", index, "snippet", "thumbnails", "high", "url")
-
-		// A completely separate API call just to get the duration of a video in a
-		// playlist? WHY GOOGLE, WHY?!
-		var durationResponse *jsonq.JsonQuery
-		url = fmt.Sprintf("https://www.googleapis.com/youtube/v3/videos?part=contentDetails&id=%s&key=%s",
-			videoID, os.Getenv("YOUTUBE_API_KEY"))
-		if durationResponse, err = PerformGetRequest(url); err != nil {
-			return nil, err
-		}
-		videoDuration, _ := durationResponse.String("items", "0", "contentDetails", "duration")
-
-		var days, hours, minutes, seconds int64
-		timestampExp := regexp.MustCompile(`P(?P<days>\d+D)?T(?P<hours>\d+H)?(?P<minutes>\d+M)?(?P<seconds>\d+S)?`)
-		timestampMatch := timestampExp.FindStringSubmatch(videoDuration)
-		timestampResult := make(map[string]string)
-		for i, name := range timestampExp.SubexpNames() {
-			if i < len(timestampMatch) {
-				timestampResult[name] = timestampMatch[i]
-			}
-		}
-
-		if timestampResult["days"] != "" {
-			days, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["days"], "D"), 10, 32)
-		}
-		if timestampResult["hours"] != "" {
-			hours, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["hours"], "H"), 10, 32)
-		}
-		if timestampResult["minutes"] != "" {
-			minutes, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["minutes"], "M"), 10, 32)
-		}
-		if timestampResult["seconds"] != "" {
-			seconds, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["seconds"], "S"), 10, 32)
-		}
-
-		totalSeconds := int((days * 86400) + (hours * 3600) + (minutes * 60) + seconds)
-		var durationString string
-		if hours != 0 {
-			if days != 0 {
-				durationString = fmt.Sprintf("%d:%02d:%02d:%02d", days, hours, minutes, seconds)
-			} else {
-				durationString = fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
-			}
-		} else {
-			durationString = fmt.Sprintf("%d:%02d", minutes, seconds)
-		}
-
-		if dj.conf.General.MaxSongDuration == 0 || totalSeconds <= dj.conf.General.MaxSongDuration {
+	var songs []*YouTubeSong
+	for _, video := range videos {
+		if dj.conf.General.MaxSongDuration == 0 || video.Duration <= dj.conf.General.MaxSongDuration {
 			playlistSong := &YouTubeSong{
 				submitter: user,
-				title:     videoTitle,
-				id:        videoID,
-				filename:  videoID + ".m4a",
-				duration:  durationString,
-				thumbnail: videoThumbnail,
+				title:     video.Title,
+				id:        video.ID,
+				filename:  songFilename(video.ID),
+				duration:  formatSongDuration(video.Duration),
+				thumbnail: video.Thumbnail,
 				skippers:  make([]string, 0),
 				playlist:  playlist,
 				dontSkip:  false,
 			}
-			dj.queue.AddSong(playlistSong)
+			songs = append(songs, playlistSong)
 		}
 	}
-	return playlist, nil
+	return playlist, songs, nil
 }
 
 // AddSkip adds a skip to the playlist's skippers slice.
@@ -445,6 +324,11 @@ func (p *YouTubePlaylist) SkipReached(channelUsers int) bool {
 	return false
 }
 
+// Skips returns the number of users that have voted to skip the YouTubePlaylist.
+func (p *YouTubePlaylist) Skips() int {
+	return len(dj.playlistSkips[p.ID()])
+}
+
 // ID returns the id of the YouTubePlaylist.
 func (p *YouTubePlaylist) ID() string {
 	return p.id
@@ -455,34 +339,321 @@ func (p *YouTubePlaylist) Title() string {
 	return p.title
 }
 
+// ----------------
+// YOUTUBE METADATA
+// ----------------
+
+// youtubePlaylistLimit is the largest number of songs queued from a playlist.
+const youtubePlaylistLimit = 25
+
+// youtubeVideo holds the metadata of a YouTube video needed to queue it. Duration is in seconds.
+type youtubeVideo struct {
+	ID        string
+	Title     string
+	Duration  int
+	Thumbnail string
+	Channel   string
+}
+
+// metadataEntry returns the metadata of the video as stored in the metadata cache.
+func (v youtubeVideo) metadataEntry() MetadataEntry {
+	return MetadataEntry{
+		Service:   "YouTube",
+		ID:        v.ID,
+		Title:     v.Title,
+		Duration:  v.Duration,
+		Thumbnail: v.Thumbnail,
+		Channel:   v.Channel,
+	}
+}
+
+// cachedYouTubeVideo returns the metadata of the video with the given ID from the metadata
+// cache, if it is there.
+func cachedYouTubeVideo(id string) (youtubeVideo, bool) {
+	entry, ok := dj.metadata.Get("YouTube", id)
+	if !ok {
+		return youtubeVideo{}, false
+	}
+	return youtubeVideo{
+		ID:        entry.ID,
+		Title:     entry.Title,
+		Duration:  entry.Duration,
+		Thumbnail: entry.Thumbnail,
+		Channel:   entry.Channel,
+	}, true
+}
+
+// youtubeMetadataFromDownloader returns whether the metadata of videos and playlists is read with
+// the downloader rather than the YouTube Data API, as chosen by Metadata in the [YouTube] section.
+// When Metadata is "auto", the API is used if an API key is set.
+func youtubeMetadataFromDownloader() bool {
+	switch dj.conf.YouTube.Metadata {
+	case "api":
+		return false
+	case "downloader":
+		return true
+	}
+	return dj.conf.YouTube.APIKey == ""
+}
+
+// FetchYouTubeVideo gathers the metadata of the YouTube video with the given ID, from the
+// metadata cache if it is there.
+func FetchYouTubeVideo(id string) (youtubeVideo, error) {
+	if video, ok := cachedYouTubeVideo(id); ok {
+		return video, nil
+	}
+	var (
+		video youtubeVideo
+		err   error
+	)
+	if youtubeMetadataFromDownloader() {
+		video, err = fetchYouTubeVideoWithDownloader(id)
+	} else {
+		video, err = fetchYouTubeVideoFromAPI(id)
+	}
+	if err != nil {
+		return video, err
+	}
+	dj.metadata.Put(video.metadataEntry())
+	return video, nil
+}
+
+// formatSongDuration formats a duration in seconds as shown to users, such as 3:25 or 1:02:03.
+func formatSongDuration(duration int) string {
+	days, hours, minutes, seconds := duration/86400, duration/3600%24, duration/60%60, duration%60
+	if days != 0 {
+		return fmt.Sprintf("%d:%02d:%02d:%02d", days, hours, minutes, seconds)
+	} else if hours != 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
+}
+
+// youtubeThumbnail returns the URL of the high quality thumbnail of the video with the given ID,
+// which is the thumbnail the YouTube Data API returns as "high".
+func youtubeThumbnail(id string) string {
+	return fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", id)
+}
+
 // -----------
 // YOUTUBE API
 // -----------
 
-// PerformGetRequest does all the grunt work for a YouTube HTTPS GET request.
-func PerformGetRequest(url string) (*jsonq.JsonQuery, error) {
-	jsonString := ""
+// parseYouTubeDuration parses a duration returned by the YouTube Data API, such as PT3M25S, into
+// seconds.
+func parseYouTubeDuration(duration string) int {
+	var days, hours, minutes, seconds int64
+	timestampExp := regexp.MustCompile(`P(?P<days>\d+D)?T(?P<hours>\d+H)?(?P<minutes>\d+M)?(?P<seconds>\d+S)?`)
+	timestampMatch := timestampExp.FindStringSubmatch(duration)
+	timestampResult := make(map[string]string)
+	for i, name := range timestampExp.SubexpNames() {
+		if i < len(timestampMatch) {
+			timestampResult[name] = timestampMatch[i]
+		}
+	}
 
-	if response, err := http.Get(url); err == nil {
-		defer response.Body.Close()
-		if response.StatusCode == 200 {
-			if body, err := ioutil.ReadAll(response.Body); err == nil {
-				jsonString = string(body)
-			}
+	if timestampResult["days"] != "" {
+		days, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["days"], "D"), 10, 32)
+	}
+	if timestampResult["hours"] != "" {
+		hours, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["hours"], "H"), 10, 32)
+	}
+	if timestampResult["minutes"] != "" {
+		minutes, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["minutes"], "M"), 10, 32)
+	}
+	if timestampResult["seconds"] != "" {
+		seconds, _ = strconv.ParseInt(strings.TrimSuffix(timestampResult["seconds"], "S"), 10, 32)
+	}
+	return int((days * 86400) + (hours * 3600) + (minutes * 60) + seconds)
+}
+
+// video returns the metadata held in v.
+func (v apiVideo) video() youtubeVideo {
+	return youtubeVideo{
+		ID:        v.ID,
+		Title:     v.Snippet.Title,
+		Duration:  parseYouTubeDuration(v.ContentDetails.Duration),
+		Thumbnail: v.Snippet.Thumbnails["high"].URL,
+		Channel:   v.Snippet.ChannelTitle,
+	}
+}
+
+// fetchYouTubeVideoFromAPI gathers the metadata of a video from the YouTube Data API.
+func fetchYouTubeVideoFromAPI(id string) (youtubeVideo, error) {
+	videos, err := NewYouTubeAPI(dj.conf).Videos(id)
+	if err != nil {
+		return youtubeVideo{}, err
+	}
+	if len(videos) == 0 {
+		return youtubeVideo{}, &YouTubeAPIError{Kind: ErrNotFound}
+	}
+	return videos[0].video(), nil
+}
+
+// fetchYouTubePlaylistFromAPI gathers the title of a playlist and the metadata of the first videos
+// within it from the YouTube Data API. The videos that are not in the metadata cache are looked
+// up together in a single request, and videos that cannot be looked up (such as deleted or
+// private videos) are left out.
+func fetchYouTubePlaylistFromAPI(id string) (string, []youtubeVideo, error) {
+	api := NewYouTubeAPI(dj.conf)
+	playlist, err := api.Playlist(id)
+	if err != nil {
+		return "", nil, err
+	}
+	items, err := api.PlaylistItems(id, youtubePlaylistLimit)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var ids, missing []string
+	found := make(map[string]youtubeVideo)
+	for _, item := range items {
+		videoID := item.Snippet.ResourceID.VideoID
+		ids = append(ids, videoID)
+		if video, ok := cachedYouTubeVideo(videoID); ok {
+			found[videoID] = video
 		} else {
-			if response.StatusCode == 403 {
-				return nil, errors.New("Invalid API key supplied.")
-			}
-			return nil, errors.New("Invalid YouTube ID supplied.")
+			missing = append(missing, videoID)
 		}
-	} else {
-		return nil, errors.New("An error occurred while receiving HTTP GET response.")
 	}
 
-	jsonData := map[string]interface{}{}
-	decoder := json.NewDecoder(strings.NewReader(jsonString))
-	decoder.Decode(&jsonData)
-	jq := jsonq.NewQuery(jsonData)
+	// The playlist items do not include the duration of each video, so the videos that are
+	// not cached are looked up separately.
+	if len(missing) > 0 {
+		results, err := api.Videos(missing...)
+		if err != nil {
+			return "", nil, err
+		}
+		var entries []MetadataEntry
+		for _, result := range results {
+			video := result.video()
+			found[video.ID] = video
+			entries = append(entries, video.metadataEntry())
+		}
+		dj.metadata.Put(entries...)
+	}
 
-	return jq, nil
+	var videos []youtubeVideo
+	for _, id := range ids {
+		if video, ok := found[id]; ok {
+			videos = append(videos, video)
+		}
+	}
+	return playlist.Snippet.Title, videos, nil
+}
+
+// ------------------
+// YOUTUBE DOWNLOADER
+// ------------------
+
+// downloaderVideo holds the fields of the JSON written by the downloader for a video that
+// MumbleDJ uses. Entries of playlists read with --flat-playlist may lack a duration.
+type downloaderVideo struct {
+	ID        string  `json:"id"`
+	Title     string  `json:"title"`
+	Duration  float64 `json:"duration"`
+	Thumbnail string  `json:"thumbnail"`
+	Channel   string  `json:"channel"`
+	Uploader  string  `json:"uploader"`
+}
+
+// downloaderPlaylist holds the fields of the JSON written by the downloader for a playlist that
+// MumbleDJ uses.
+type downloaderPlaylist struct {
+	Title   string            `json:"title"`
+	Entries []downloaderVideo `json:"entries"`
+}
+
+// runDownloaderJSON runs the downloader with the given arguments and decodes the JSON it writes
+// into value. The downloader is given MetadataTimeout seconds, and is tracked with the running
+// downloads so that it is stopped if MumbleDJ shuts down. Errors are classified in the same way as
+// failed downloads.
+func runDownloaderJSON(value interface{}, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(dj.conf.Downloader.MetadataTimeout)*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := downloaderCommand(ctx, dj.conf, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := downloads.Run(cmd, ""); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return &DownloadError{Reason: ErrDownloadTimeout, Attempts: 1}
+		}
+		failure := lastLines(stderr.String(), 5)
+		return &DownloadError{Reason: classifyDownloadError(failure), Output: failure, Attempts: 1}
+	}
+	return json.Unmarshal(stdout.Bytes(), value)
+}
+
+// downloaderMetadataError returns the error to report when the downloader could not read the
+// metadata of a video or playlist. The downloader timing out is reported like a failure to reach
+// the YouTube Data API, and anything else as an invalid ID.
+func downloaderMetadataError(err error) error {
+	if errors.Is(err, ErrDownloadTimeout) {
+		return ErrNetwork
+	}
+	return ErrNotFound
+}
+
+// video returns the metadata held in v, filling in the thumbnail if the downloader did not give one.
+func (v downloaderVideo) video() youtubeVideo {
+	video := youtubeVideo{
+		ID:        v.ID,
+		Title:     v.Title,
+		Duration:  int(v.Duration),
+		Thumbnail: v.Thumbnail,
+		Channel:   v.Channel,
+	}
+	if video.Channel == "" {
+		video.Channel = v.Uploader
+	}
+	if video.Thumbnail == "" {
+		video.Thumbnail = youtubeThumbnail(v.ID)
+	}
+	return video
+}
+
+// fetchYouTubeVideoWithDownloader gathers the metadata of a video from the JSON written by the
+// downloader with --dump-json, without downloading the video.
+func fetchYouTubeVideoWithDownloader(id string) (youtubeVideo, error) {
+	var result downloaderVideo
+	if err := runDownloaderJSON(&result, "--dump-json", "--no-playlist", "--skip-download", "--", id); err != nil {
+		logger.WithField("song", id).WithError(err).Warn("Could not read the metadata of a video with the downloader.")
+		return youtubeVideo{}, downloaderMetadataError(err)
+	}
+	return result.video(), nil
+}
+
+// fetchYouTubePlaylistWithDownloader gathers the title of a playlist and the metadata of the first
+// videos within it from the JSON written by the downloader with --flat-playlist. Videos listed
+// without a duration, as youtube-dl lists them, are looked up one by one unless they are in the
+// metadata cache.
+func fetchYouTubePlaylistWithDownloader(id string) (string, []youtubeVideo, error) {
+	var result downloaderPlaylist
+	url := "https://www.youtube.com/playlist?list=" + id
+	if err := runDownloaderJSON(&result, "--dump-single-json", "--flat-playlist", "--playlist-end", strconv.Itoa(youtubePlaylistLimit), "--", url); err != nil {
+		logger.WithField("playlist", id).WithError(err).Warn("Could not read the metadata of a playlist with the downloader.")
+		return "", nil, downloaderMetadataError(err)
+	}
+
+	var videos []youtubeVideo
+	for _, entry := range result.Entries {
+		if entry.ID == "" {
+			continue
+		}
+		if entry.Duration == 0 {
+			video, err := FetchYouTubeVideo(entry.ID)
+			if err != nil {
+				continue
+			}
+			videos = append(videos, video)
+		} else {
+			videos = append(videos, entry.video())
+		}
+		if len(videos) == youtubePlaylistLimit {
+			break
+		}
+	}
+	return result.Title, videos, nil
 }
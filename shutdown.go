@@ -0,0 +1,226 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * shutdown.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// RunningDownloads keeps track of the youtube-dl processes that are running, so that shutting
+// down can wait for them to finish or stop them.
+type RunningDownloads struct {
+	mutex sync.Mutex
+	cmds  map[*exec.Cmd]string
+}
+
+// downloads holds every youtube-dl process started by MumbleDJ.
+var downloads = &RunningDownloads{cmds: make(map[*exec.Cmd]string)}
+
+// Run runs cmd, which downloads or transcodes the song stored in filename within the songs
+// directory. If this fails, any partial file left behind for the song is removed. filename is
+// empty for commands that only read metadata. No new downloads are started once MumbleDJ is
+// shutting down.
+func (d *RunningDownloads) Run(cmd *exec.Cmd, filename string) error {
+	d.mutex.Lock()
+	if dj.ShuttingDown() {
+		d.mutex.Unlock()
+		return errors.New("MumbleDJ is shutting down.")
+	}
+	if err := cmd.Start(); err != nil {
+		d.mutex.Unlock()
+		return err
+	}
+	d.cmds[cmd] = filename
+	d.mutex.Unlock()
+
+	err := cmd.Wait()
+
+	d.mutex.Lock()
+	delete(d.cmds, cmd)
+	d.mutex.Unlock()
+	if err != nil && filename != "" {
+		partial, _ := filepath.Glob(songPath(filename) + ".*")
+		for _, path := range partial {
+			if isPartialDownload(path) {
+				os.Remove(path)
+			}
+		}
+	}
+	return err
+}
+
+// Len returns the number of downloads that are running.
+func (d *RunningDownloads) Len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.cmds)
+}
+
+// Has returns whether the song stored in filename is being downloaded.
+func (d *RunningDownloads) Has(filename string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, downloading := range d.cmds {
+		if downloading == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait waits until no downloads are running.
+func (d *RunningDownloads) Wait() {
+	for d.Len() > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Kill stops every download that is running.
+func (d *RunningDownloads) Kill() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for cmd := range d.cmds {
+		cmd.Process.Kill()
+	}
+}
+
+// shutdownGrace is how long the rest of shutting down may take once the downloads that were
+// running when the timeout was reached have been stopped.
+const shutdownGrace = 5 * time.Second
+
+// HandleSignals shuts MumbleDJ down gracefully when it receives SIGINT or SIGTERM. If a second
+// signal is received while shutting down, MumbleDJ exits straight away.
+func HandleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		logger.WithField("signal", received.String()).Info("Shutting down.")
+		go func() {
+			<-signals
+			logger.Warn("Received a second signal while shutting down, exiting now.")
+			os.Exit(1)
+		}()
+		ShutdownAndExit(dj.conf.Shutdown.DeleteSongs)
+	}()
+}
+
+// shutdownOnce makes sure that MumbleDJ only begins shutting down once, however many times it
+// is asked to.
+var shutdownOnce sync.Once
+
+// ShutdownAndExit shuts MumbleDJ down gracefully, deleting the downloaded songs if removeSongs
+// is true, and then exits. The exit status is 1 if shutting down did not finish in time. Only the
+// first call shuts down, and any later calls block until MumbleDJ exits.
+func ShutdownAndExit(removeSongs bool) {
+	shutdownOnce.Do(func() {
+		if !Shutdown(removeSongs) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	})
+	select {}
+}
+
+// ShuttingDown returns whether MumbleDJ has begun shutting down. It may be called from any
+// goroutine.
+func (dj *mumbledj) ShuttingDown() bool {
+	return atomic.LoadInt32(&dj.shuttingDown) != 0
+}
+
+// Shutdown stops MumbleDJ gracefully. The audio stream is stopped, songs that are being downloaded
+// are given until the Timeout in the [Shutdown] section to finish, the queue is saved and the
+// songs are deleted if removeSongs is true, and MumbleDJ then disconnects from the server.
+// Downloads still running once the timeout is reached are stopped. Returns whether everything
+// finished in time.
+func Shutdown(removeSongs bool) bool {
+	timeout := time.Duration(dj.conf.Shutdown.Timeout) * time.Second
+	// The flag is set while downloads.mutex is held so that no download can start after the
+	// running ones have been counted.
+	downloads.mutex.Lock()
+	atomic.StoreInt32(&dj.shuttingDown, 1)
+	downloads.mutex.Unlock()
+
+	finished := make(chan bool)
+	go func() {
+		if running := downloads.Len(); running > 0 {
+			logger.WithField("downloads", running).Info("Waiting for downloads to finish.")
+		}
+		downloads.Wait()
+		shutdownState(removeSongs)
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+	}
+	if running := downloads.Len(); running > 0 {
+		logger.WithField("downloads", running).Warn("Downloads did not finish in time, stopping them.")
+		downloads.Kill()
+	}
+	select {
+	case <-finished:
+		return true
+	case <-time.After(shutdownGrace):
+		logger.Error("Could not shut down cleanly in time.")
+		return false
+	}
+}
+
+// shutdownState stops playback, saves the queue, deletes the songs if removeSongs is true, says
+// goodbye and disconnects. It is called once no downloads are running.
+func shutdownState(removeSongs bool) {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
+	if dj.audioStream != nil && dj.audioStream.IsPlaying() && !dj.paused {
+		if err := dj.Pause(); err != nil {
+			logger.WithError(err).Error("An error occurred while stopping the audio stream.")
+		}
+	}
+
+	saved := 0
+	if dj.conf.Shutdown.SaveState {
+		var err error
+		if saved, err = SaveState(); err != nil {
+			logger.WithError(err).Error("An error occurred while saving the queue.")
+		} else if saved > 0 {
+			logger.WithField("songs", saved).Info("Saved the queue.")
+		}
+	}
+	if removeSongs {
+		if err := deleteSongs(); err != nil {
+			logger.WithError(err).Error("An error occurred while deleting the audio files.")
+		}
+	}
+
+	StopControlSocket()
+	StopAPIServer()
+	StopMPDServer()
+	StopConfigWatcher()
+	dj.cache.StopExpiry()
+
+	if dj.client != nil && dj.connected {
+		announce(nil, dj.messages.Render(SHUTTING_DOWN_MSG, MessageData{Count: saved}))
+		if err := dj.client.Disconnect(); err != nil {
+			logger.WithError(err).Error("An error occurred while disconnecting from the server.")
+			return
+		}
+	}
+	logger.Info("Shutdown complete. Goodbye!")
+}
@@ -10,25 +10,53 @@ package main
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 )
 
-// SongQueue type declaration.
+// SongQueue type declaration. The queue itself is only used while commandMutex is held, but the
+// names of the files it needs are kept in files, guarded by mutex, so that they may be checked
+// from any goroutine.
 type SongQueue struct {
-	queue []Song
+	queue     []Song
+	preparing Song
+	mutex     sync.Mutex
+	files     map[string]bool
 }
 
 // NewSongQueue initializes a new queue and returns it.
 func NewSongQueue() *SongQueue {
 	return &SongQueue{
 		queue: make([]Song, 0),
+		files: make(map[string]bool),
 	}
 }
 
+// updateFiles records the names of the files needed by the songs in the queue. It must be called
+// whenever the queue changes.
+func (q *SongQueue) updateFiles() {
+	files := make(map[string]bool, len(q.queue))
+	for _, s := range q.queue {
+		files[s.Filename()] = true
+	}
+	q.mutex.Lock()
+	q.files = files
+	q.mutex.Unlock()
+}
+
+// Queued returns whether a song in the queue is stored in filename. Unlike the other methods, it
+// may be called without holding commandMutex.
+func (q *SongQueue) Queued(filename string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.files[filename]
+}
+
 // AddSong adds a Song to the SongQueue.
 func (q *SongQueue) AddSong(s Song) error {
 	beforeLen := q.Len()
 	q.queue = append(q.queue, s)
+	q.updateFiles()
 	if len(q.queue) == beforeLen+1 {
 		return nil
 	}
@@ -52,6 +80,38 @@ func (q *SongQueue) NextSong() {
 		}
 	}
 	q.queue = q.queue[1:]
+	q.updateFiles()
+}
+
+// RemovePlaylist removes every Song from the given playlist from the SongQueue.
+func (q *SongQueue) RemovePlaylist(id string) {
+	for i := 0; i < len(q.queue); i++ {
+		if q.queue[i].Playlist() != nil {
+			if q.queue[i].Playlist().ID() == id {
+				q.queue = append(q.queue[:i], q.queue[i+1:]...)
+				i--
+			}
+		}
+	}
+	q.updateFiles()
+}
+
+// Clear removes every Song from the SongQueue.
+func (q *SongQueue) Clear() {
+	q.queue = q.queue[:0]
+	q.updateFiles()
+}
+
+// RemoveSong removes the Song at position i from the SongQueue and returns it. The current Song
+// cannot be removed, as it must be skipped instead.
+func (q *SongQueue) RemoveSong(i int) (Song, error) {
+	if i < 1 || i >= q.Len() {
+		return nil, errors.New("There isn't a Song that can be removed at that position.")
+	}
+	s := q.queue[i]
+	q.queue = append(q.queue[:i:i], q.queue[i+1:]...)
+	q.updateFiles()
+	return s, nil
 }
 
 // PeekNext peeks at the next Song and returns it.
@@ -77,28 +137,56 @@ func (q *SongQueue) Traverse(visit func(i int, s Song)) {
 
 // OnSongFinished event. Deletes Song that just finished playing, then queues the next Song (if exists).
 func (q *SongQueue) OnSongFinished() {
+	if dj.paused {
+		// The song was stopped in order to pause it, so it stays at the front of the queue.
+		return
+	}
 	resetOffset, _ := time.ParseDuration(fmt.Sprintf("%ds", 0))
 	dj.audioStream.Offset = resetOffset
 	if q.Len() != 0 {
 		if dj.queue.CurrentSong().DontSkip() == true {
 			dj.queue.CurrentSong().SetDontSkip(false)
-			q.PrepareAndPlayNextSong()
+			q.PrepareAndPlayNextSong(nil)
 		} else {
+			dj.events.OnSongFinished(&SongFinishedEvent{Song: q.CurrentSong()})
 			q.NextSong()
 			if q.Len() != 0 {
-				q.PrepareAndPlayNextSong()
+				q.PrepareAndPlayNextSong(nil)
 			}
 		}
 	}
 }
 
-// PrepareAndPlayNextSong prepares next song and plays it if the download succeeds.
-// Otherwise the function will print an error message to the channel and skip to the next song.
-func (q *SongQueue) PrepareAndPlayNextSong() {
-	if err := q.CurrentSong().Download(); err == nil {
-		q.CurrentSong().Play()
-	} else {
-		dj.client.Self.Channel.Send(AUDIO_FAIL_MSG, false)
-		q.OnSongFinished()
+// PrepareAndPlayNextSong downloads the current song in the background and plays it if the download
+// succeeds. Otherwise the failure is announced and the queue moves on to the next song. sender is
+// the user whose command led to the song being played, if any. Must be called while commandMutex
+// is held, although the lock is not held during the download, so that other commands are not held
+// up by it. Nothing is done while MumbleDJ is shutting down, so that the song stays in the queue.
+func (q *SongQueue) PrepareAndPlayNextSong(sender CommandSender) {
+	if dj.ShuttingDown() || q.Len() == 0 || q.preparing == q.CurrentSong() {
+		return
 	}
+	s := q.CurrentSong()
+	q.preparing = s
+	go func() {
+		err := s.Download()
+
+		commandMutex.Lock()
+		defer commandMutex.Unlock()
+		if q.preparing != s {
+			// Another song has been prepared since, such as after the queue was reset.
+			return
+		}
+		q.preparing = nil
+		if q.Len() == 0 || q.CurrentSong() != s || dj.ShuttingDown() {
+			return
+		}
+		if err == nil {
+			s.Play()
+		} else {
+			dj.events.OnDownloadFailed(&DownloadFailedEvent{Sender: sender, Song: s, Err: err})
+			s.Delete()
+			q.OnSongFinished()
+		}
+	}()
 }
@@ -0,0 +1,151 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * state.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// SavedSong is a song in the queue as it is stored in the state file. Offset is the position in
+// seconds from which the song starts playing, which for the first song is the position it had
+// reached when MumbleDJ shut down.
+type SavedSong struct {
+	ID            string `json:"id"`
+	Title         string `json:"title"`
+	Duration      string `json:"duration"`
+	Thumbnail     string `json:"thumbnail"`
+	Submitter     string `json:"submitter"`
+	PlaylistID    string `json:"playlist_id,omitempty"`
+	PlaylistTitle string `json:"playlist_title,omitempty"`
+	Offset        int    `json:"offset,omitempty"`
+}
+
+// SavedState is the contents of the state file, which holds the queue and volume from when
+// MumbleDJ last shut down.
+type SavedState struct {
+	Saved  time.Time   `json:"saved"`
+	Volume float32     `json:"volume"`
+	Queue  []SavedSong `json:"queue"`
+}
+
+// stateFilePath returns the path of the state file set in the [Shutdown] section, or
+// state.json in the state directory if none is set.
+func stateFilePath() string {
+	if dj.conf.Shutdown.StateFile != "" {
+		return dj.conf.Shutdown.StateFile
+	}
+	return statePath("state.json")
+}
+
+// SaveState writes the queue and volume to the state file, and returns the number of songs
+// saved. The position reached within the current song is saved along with it. Must be called
+// while commandMutex is held.
+func SaveState() (int, error) {
+	state := SavedState{
+		Saved:  time.Now().UTC(),
+		Volume: dj.conf.Volume.DefaultVolume,
+		Queue:  make([]SavedSong, 0, dj.queue.Len()),
+	}
+	if dj.audioStream != nil {
+		state.Volume = dj.audioStream.Volume
+	}
+	dj.queue.Traverse(func(i int, song Song) {
+		saved := SavedSong{
+			ID:        song.ID(),
+			Title:     song.Title(),
+			Duration:  song.Duration(),
+			Thumbnail: song.Thumbnail(),
+			Submitter: song.Submitter(),
+			Offset:    song.Offset(),
+		}
+		if song.Playlist() != nil {
+			saved.PlaylistID = song.Playlist().ID()
+			saved.PlaylistTitle = song.Playlist().Title()
+		}
+		if i == 0 && dj.HasCurrentSong() {
+			saved.Offset = int(dj.Elapsed().Seconds())
+		}
+		state.Queue = append(state.Queue, saved)
+	})
+
+	path := stateFilePath()
+	if len(state.Queue) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		return 0, nil
+	}
+	contents, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return 0, err
+	}
+	if err := ioutil.WriteFile(path+".tmp", contents, 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return 0, err
+	}
+	return len(state.Queue), nil
+}
+
+// RestoreState adds the songs in the state file to the queue and restores the volume, then
+// removes the file so that the songs are only restored once. Nothing is done if the queue is not
+// empty. The first song starts playing from where it was when MumbleDJ shut down.
+func RestoreState() {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
+	path := stateFilePath()
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || dj.queue.Len() != 0 {
+		return
+	} else if err != nil {
+		logger.WithError(err).Error("An error occurred while reading the state file.")
+		return
+	}
+	os.Remove(path)
+
+	var state SavedState
+	if err := json.Unmarshal(contents, &state); err != nil {
+		logger.WithField("path", path).WithError(err).Error("The state file is not valid, so the queue was not restored.")
+		return
+	}
+
+	if state.Volume >= dj.conf.Volume.LowestVolume && state.Volume <= dj.conf.Volume.HighestVolume {
+		dj.audioStream.Volume = state.Volume
+		dj.volumeSet = true
+	}
+	playlists := make(map[string]*YouTubePlaylist)
+	for _, saved := range state.Queue {
+		song := &YouTubeSong{
+			submitter: saved.Submitter,
+			title:     saved.Title,
+			id:        saved.ID,
+			offset:    saved.Offset,
+			filename:  songFilename(saved.ID),
+			duration:  saved.Duration,
+			thumbnail: saved.Thumbnail,
+			skippers:  make([]string, 0),
+		}
+		if saved.PlaylistID != "" {
+			if playlists[saved.PlaylistID] == nil {
+				playlists[saved.PlaylistID] = &YouTubePlaylist{id: saved.PlaylistID, title: saved.PlaylistTitle}
+			}
+			song.playlist = playlists[saved.PlaylistID]
+		}
+		dj.queue.AddSong(song)
+	}
+	logger.WithField("songs", dj.queue.Len()).Info("Restored the queue saved when MumbleDJ last shut down.")
+
+	if dj.queue.Len() > 0 {
+		dj.queue.PrepareAndPlayNextSong(nil)
+	}
+}
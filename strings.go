@@ -8,165 +8,419 @@
 package main
 
 // Message shown to users when the bot has an invalid YouTube API key.
-const INVALID_API_KEY = "MumbleDJ does not have a valid YouTube API key."
+const INVALID_API_KEY = "invalid_api_key"
+
+// Message shown to users when the bot has used up its YouTube API quota for the day.
+const YOUTUBE_QUOTA_EXCEEDED_MSG = "youtube_quota_exceeded"
+
+// Message shown to users when YouTube refuses access to the video or playlist they supplied.
+const YOUTUBE_FORBIDDEN_MSG = "youtube_forbidden"
+
+// Message shown to users when the YouTube API could not be reached.
+const YOUTUBE_UNAVAILABLE_MSG = "youtube_unavailable"
 
 // Message shown to users when they do not have permission to execute a command.
-const NO_PERMISSION_MSG = "You do not have permission to execute that command."
+const NO_PERMISSION_MSG = "no_permission"
+
+// Message shown to users when a command fails because of an unexpected error.
+const COMMAND_ERROR_MSG = "command_error"
 
 // Message shown to users when they try to add a playlist to the queue and do not have permission to do so.
-const NO_PLAYLIST_PERMISSION_MSG = "You do not have permission to add playlists to the queue."
+const NO_PLAYLIST_PERMISSION_MSG = "no_playlist_permission"
+
+// Message shown to users when they add a playlist, while its songs are being looked up.
+const PLAYLIST_LOOKUP_MSG = "playlist_lookup"
+
+// Message shown to users when they issue a command from outside of the bot's channel that may not be issued remotely.
+const REMOTE_COMMAND_NOT_ALLOWED_MSG = "remote_command_not_allowed"
 
 // Message shown to users when they try to execute a command that doesn't exist.
-const COMMAND_DOESNT_EXIST_MSG = "The command you entered does not exist."
+const COMMAND_DOESNT_EXIST_MSG = "command_doesnt_exist"
 
 // Message shown to users when they try to move the bot to a non-existant channel.
-const CHANNEL_DOES_NOT_EXIST_MSG = "The channel you specified does not exist."
+const CHANNEL_DOES_NOT_EXIST_MSG = "channel_does_not_exist"
 
 // Message shown to users when they attempt to add an invalid URL to the queue.
-const INVALID_URL_MSG = "The URL you submitted does not match the required format."
+const INVALID_URL_MSG = "invalid_url"
 
 // Message shown to users when they attempt to add a video that's too long
-const VIDEO_TOO_LONG_MSG = "The video you submitted exceeds the duration allowed by the server."
+const VIDEO_TOO_LONG_MSG = "video_too_long"
 
 // Message shown to users when they attempt to perform an action on a song when
 // no song is playing.
-const NO_MUSIC_PLAYING_MSG = "There is no music playing at the moment."
+const NO_MUSIC_PLAYING_MSG = "no_music_playing"
 
 // Message shown to users when they attempt to skip a playlist when there is no playlist playing.
-const NO_PLAYLIST_PLAYING_MSG = "There is no playlist playing at the moment."
+const NO_PLAYLIST_PLAYING_MSG = "no_playlist_playing"
 
 // Message shown to users when they attempt to use the nextsong command when there is no song coming up.
-const NO_SONG_NEXT_MSG = "There are no songs queued at the moment."
+const NO_SONG_NEXT_MSG = "no_song_next"
 
 // Message shown to users when they issue a command that requires an argument and one was not supplied.
-const NO_ARGUMENT_MSG = "The command you issued requires an argument and you did not provide one."
+const NO_ARGUMENT_MSG = "no_argument"
 
 // Message shown to users when they try to change the volume to a value outside the volume range.
-const NOT_IN_VOLUME_RANGE_MSG = "Out of range. The volume must be between %f and %f."
+const NOT_IN_VOLUME_RANGE_MSG = "not_in_volume_range"
+
+// Message shown to users when they try to pause the music while it is already paused.
+const ALREADY_PAUSED_MSG = "already_paused"
+
+// Message shown to users when they try to resume the music while it is not paused.
+const NOT_PAUSED_MSG = "not_paused"
 
 // Message shown to user when a successful configuration reload finishes.
-const CONFIG_RELOAD_SUCCESS_MSG = "The configuration has been successfully reloaded."
+const CONFIG_RELOAD_SUCCESS_MSG = "config_reload_success"
+
+// Message shown to user when a configuration reload finishes without any settings changing.
+const CONFIG_RELOAD_UNCHANGED_MSG = "config_reload_unchanged"
+
+// Message shown to user when the configuration could not be reloaded.
+const CONFIG_RELOAD_FAILED_MSG = "config_reload_failed"
 
 // Message shown to users when an admin skips a song.
-const ADMIN_SONG_SKIP_MSG = "An admin has decided to skip the current song."
+const ADMIN_SONG_SKIP_MSG = "admin_song_skip"
 
 // Message shown to users when an admin skips a playlist.
-const ADMIN_PLAYLIST_SKIP_MSG = "An admin has decided to skip the current playlist."
+const ADMIN_PLAYLIST_SKIP_MSG = "admin_playlist_skip"
 
 // Message shown to users when the audio for a video could not be downloaded.
-const AUDIO_FAIL_MSG = "The audio download for this video failed. YouTube has likely not generated the audio files for this video yet. Skipping to the next song!"
+const AUDIO_FAIL_MSG = "audio_fail"
 
 // Message shown to users when they supply a YouTube URL that does not contain a valid ID.
-const INVALID_YOUTUBE_ID_MSG = "The YouTube URL you supplied did not contain a valid YouTube ID."
+const INVALID_YOUTUBE_ID_MSG = "invalid_youtube_id"
 
 // Message shown to user when they successfully update the bot's comment.
-const COMMENT_UPDATED_MSG = "The comment for the bot has successfully been updated."
+const COMMENT_UPDATED_MSG = "comment_updated"
 
 // Message shown to user when they request to see the number of songs cached on disk.
-const NUM_CACHED_MSG = "There are currently %d songs cached on disk."
+const NUM_CACHED_MSG = "num_cached"
 
 // Message shown to user when they request to see the total size of the cache.
-const CACHE_SIZE_MSG = "The cache is currently %g MB in size."
+const CACHE_SIZE_MSG = "cache_size"
 
 // Message shown to user when they attempt to issue a cache-related command when caching is not enabled.
-const CACHE_NOT_ENABLED_MSG = "The cache is not currently enabled."
+const CACHE_NOT_ENABLED_MSG = "cache_not_enabled"
+
+// Message shown to users when they issue the cache command without a valid subcommand.
+const CACHE_USAGE_MSG = "cache_usage"
+
+// Message shown to users when they refer to a song that is not in the cache.
+const NOT_CACHED_MSG = "not_cached"
+
+// Message shown to users when they list the songs in the cache. Features the ID, title, size,
+// age and play count of each song on the page.
+const CACHE_LIST_HTML = "cache_list"
+
+// Message shown to users when they list the songs in the cache and it is empty.
+const CACHE_EMPTY_MSG = "cache_empty"
+
+// Message shown to users when they purge songs from the cache.
+const CACHE_PURGED_MSG = "cache_purged"
+
+// Message shown to users when they ask for the cache to be prewarmed with a playlist.
+const CACHE_PREWARM_REQUESTED_MSG = "cache_prewarm_requested"
+
+// Message shown to users when the songs in a playlist start being downloaded into the cache.
+const CACHE_PREWARM_STARTED_MSG = "cache_prewarm_started"
+
+// Message shown to users every few songs while the cache is being prewarmed.
+const CACHE_PREWARM_PROGRESS_MSG = "cache_prewarm_progress"
+
+// Message shown to users when the cache has been prewarmed with a playlist.
+const CACHE_PREWARM_FINISHED_MSG = "cache_prewarm_finished"
+
+// Message shown to users when they request to see how much YouTube Data API quota has been used
+// today.
+const QUOTA_MSG = "quota"
+
+// Message shown to users when they pin a song in the cache.
+const CACHE_PINNED_MSG = "cache_pinned"
+
+// Message shown to users when they unpin a song in the cache.
+const CACHE_UNPINNED_MSG = "cache_unpinned"
 
 // Message shown to channel when a song is added to the queue by a user.
-const SONG_ADDED_HTML = `
-	<b>%s</b> has added "%s" to the queue.
-`
+const SONG_ADDED_HTML = "song_added"
 
 // Message shown to channel when a playlist is added to the queue by a user.
-const PLAYLIST_ADDED_HTML = `
-	<b>%s</b> has added the playlist "%s" to the queue.
-`
+const PLAYLIST_ADDED_HTML = "playlist_added"
 
 // Message shown to channel when a song has been skipped.
-const SONG_SKIPPED_HTML = `
-	The number of votes required for a skip has been met. <b>Skipping song!</b>
-`
+const SONG_SKIPPED_HTML = "song_skipped"
 
 // Message shown to channel when a playlist has been skipped.
-const PLAYLIST_SKIPPED_HTML = `
-	The number of votes required for a skip has been met. <b>Skipping playlist!</b>
-`
+const PLAYLIST_SKIPPED_HTML = "playlist_skipped"
 
 // Message shown to display bot commands.
-const HELP_HTML = `<br/>
-	<b>User Commands:</b>
-	<p><b>!help</b> - Displays this help.</p>
-	<p><b>!add</b> - Adds songs to queue.</p>
-	<p><b>!volume</b> - Either tells you the current volume or sets it to a new volume.</p>
-	<p><b>!skip</b> - Casts a vote to skip the current song</p>
-	<p> <b>!skipplaylist</b> - Casts a vote to skip over the current playlist.</p>
-	<p><b>!numsongs</b> - Shows how many songs are in queue.</p>
-	<p><b>!nextsong</b> - Shows the title and submitter of the next queue item if it exists.</p>
-	<p><b>!currentsong</b> - Shows the title and submitter of the song currently playing.</p>
-	<p style="-qt-paragraph-type:empty"><br/></p>
-	<p><b>Admin Commands:</b></p>
-	<p><b>!reset</b> - An admin command that resets the song queue. </p>
-	<p><b>!forceskip</b> - An admin command that forces a song skip. </p>
-	<p><b>!forceskipplaylist</b> - An admin command that forces a playlist skip. </p>
-	<p><b>!move </b>- Moves MumbleDJ into channel if it exists.</p>
-	<p><b>!reload</b> - Reloads mumbledj.gcfg configuration settings.</p>
-	<p><b>!setcomment</b> - Sets the comment for the bot.</p>
-	<p><b>!numcached</b></p> - Outputs the number of songs cached on disk.</p>
-	<p><b>!cachesize</b></p> - Outputs the total file size of the cache in MB.</p>
-	<p><b>!kill</b> - Safely cleans the bot environment and disconnects from the server.</p>
-`
+const HELP_HTML = "help"
 
 // Message shown to users when they ask for the current volume (volume command without argument)
-const CUR_VOLUME_HTML = `
-	The current volume is <b>%.2f</b>.
-`
+const CUR_VOLUME_HTML = "cur_volume"
 
 // Message shown to users when another user votes to skip the current song.
-const SKIP_ADDED_HTML = `
-	<b>%s</b> has voted to skip the current song.
-`
+const SKIP_ADDED_HTML = "skip_added"
 
 // Message shown to users when the submitter of a song decides to skip their song.
-const SUBMITTER_SKIP_HTML = `
-	The current song has been skipped by <b>%s</b>, the submitter.
-`
+const SUBMITTER_SKIP_HTML = "submitter_skip"
 
 // Message shown to users when another user votes to skip the current playlist.
-const PLAYLIST_SKIP_ADDED_HTML = `
-	<b>%s</b> has voted to skip the current playlist.
-`
+const PLAYLIST_SKIP_ADDED_HTML = "playlist_skip_added"
 
 // Message shown to users when the submitter of a song decides to skip their song.
-const PLAYLIST_SUBMITTER_SKIP_HTML = `
-	The current playlist has been skipped by <b>%s</b>, the submitter.
-`
+const PLAYLIST_SUBMITTER_SKIP_HTML = "playlist_submitter_skip"
 
 // Message shown to users when they successfully change the volume.
-const VOLUME_SUCCESS_HTML = `
-	<b>%s</b> has changed the volume to <b>%.2f</b>.
-`
+const VOLUME_SUCCESS_HTML = "volume_success"
+
+// Message shown to users when a user pauses the music.
+const PAUSED_HTML = "paused"
+
+// Message shown to users when a user resumes the music.
+const RESUMED_HTML = "resumed"
+
+// Message shown to users when a user removes a song from the queue.
+const SONG_REMOVED_HTML = "song_removed"
 
 // Message shown to users when a user successfully resets the SongQueue.
-const QUEUE_RESET_HTML = `
-	<b>%s</b> has cleared the song queue.
-`
+const QUEUE_RESET_HTML = "queue_reset"
 
 // Message shown to users when a user asks how many songs are in the queue.
-const NUM_SONGS_HTML = `
-	There are currently <b>%d</b> song(s) in the queue.
-`
+const NUM_SONGS_HTML = "num_songs"
 
 // Message shown to users when they issue the nextsong command.
-const NEXT_SONG_HTML = `
-	The next song in the queue is "%s", added by <b>%s</b>.
-`
+const NEXT_SONG_HTML = "next_song"
 
 // Message shown to users when they issue the currentsong command.
-const CURRENT_SONG_HTML = `
-	The song currently playing is "%s", added by <b>%s</b>.
-`
+const CURRENT_SONG_HTML = "current_song"
 
 // Message shown to users when the currentsong command is issued when a song from a
 // playlist is playing.
-const CURRENT_SONG_PLAYLIST_HTML = `
-	The song currently playing is "%s", added <b>%s</b> from the playlist "%s".
+const CURRENT_SONG_PLAYLIST_HTML = "current_song_playlist"
+
+// Message shown to channel when a new song starts playing. Features the video thumbnail,
+// URL, title, duration, submitter, and playlist title (if exists).
+const NOW_PLAYING_HTML = "now_playing"
+
+// Message shown to channel when MumbleDJ shuts down after receiving a signal.
+const SHUTTING_DOWN_MSG = "shutting_down"
+
+// DEFAULT_MESSAGES contains the English message templates that ship with MumbleDJ. Each message
+// is a text/template definition named after one of the constants above. Locale bundles and
+// custom message files only need to redefine the messages they wish to change.
+const DEFAULT_MESSAGES = `
+{{define "invalid_api_key"}}MumbleDJ does not have a valid YouTube API key.{{end}}
+
+{{define "youtube_quota_exceeded"}}MumbleDJ has used up its YouTube API quota for today. Please try again later.{{end}}
+
+{{define "youtube_forbidden"}}The YouTube video or playlist you supplied cannot be accessed. It may be private.{{end}}
+
+{{define "youtube_unavailable"}}MumbleDJ could not reach YouTube. Please try again later.{{end}}
+
+{{define "no_permission"}}You do not have permission to execute that command.{{end}}
+
+{{define "command_error"}}{{.Error}} The error has been logged, so please ask an admin to check the log if this keeps happening.{{end}}
+
+{{define "no_playlist_permission"}}You do not have permission to add playlists to the queue.{{end}}
+
+{{define "playlist_lookup"}}The playlist is being looked up, and its songs will be added to the queue shortly.{{end}}
+
+{{define "remote_command_not_allowed"}}That command may only be issued from within the bot's channel.{{end}}
+
+{{define "command_doesnt_exist"}}The command you entered does not exist.{{end}}
+
+{{define "channel_does_not_exist"}}The channel you specified does not exist. {{.Channel}}{{end}}
+
+{{define "invalid_url"}}The URL you submitted does not match the required format.{{end}}
+
+{{define "video_too_long"}}The video you submitted exceeds the duration allowed by the server.{{end}}
+
+{{define "no_music_playing"}}There is no music playing at the moment.{{end}}
+
+{{define "no_playlist_playing"}}There is no playlist playing at the moment.{{end}}
+
+{{define "no_song_next"}}There are no songs queued at the moment.{{end}}
+
+{{define "no_argument"}}The command you issued requires an argument and you did not provide one.{{end}}
+
+{{define "not_in_volume_range"}}Out of range. The volume must be between {{.LowestVolume}} and {{.HighestVolume}}.{{end}}
+
+{{define "already_paused"}}The music is already paused.{{end}}
+
+{{define "not_paused"}}The music is not paused at the moment.{{end}}
+
+{{define "config_reload_success"}}The configuration has been successfully reloaded. The following settings were changed:{{range .Changes}}<br/>{{.}}{{end}}{{end}}
+
+{{define "config_reload_unchanged"}}The configuration has been successfully reloaded. No settings were changed.{{end}}
+
+{{define "config_reload_failed"}}The configuration could not be reloaded, so the previous settings are still in use:{{range .Errors}}<br/>{{.}}{{end}}{{end}}
+
+{{define "admin_song_skip"}}An admin has decided to skip the current song.{{end}}
+
+{{define "admin_playlist_skip"}}An admin has decided to skip the current playlist.{{end}}
+
+{{define "audio_fail"}}{{if eq .Reason "geo_blocked"}}The audio download for this video failed, as it is not available in the bot's country.{{else if eq .Reason "removed"}}The audio download for this video failed, as it has been removed or made private.{{else if eq .Reason "age_restricted"}}The audio download for this video failed, as it is age-restricted.{{else if eq .Reason "rate_limited"}}The audio download for this video failed, as YouTube is limiting the bot's downloads. Try again later.{{else if eq .Reason "timeout"}}The audio download for this video took too long and was stopped.{{else}}The audio download for this video failed. YouTube has likely not generated the audio files for this video yet.{{end}} Skipping to the next song!{{end}}
+
+{{define "invalid_youtube_id"}}The YouTube URL you supplied did not contain a valid YouTube ID.{{end}}
+
+{{define "comment_updated"}}The comment for the bot has successfully been updated.{{end}}
+
+{{define "num_cached"}}There are currently {{.Count}} songs cached on disk.{{end}}
+
+{{define "cache_size"}}The cache is currently {{.Size}} MB in size.{{end}}
+
+{{define "cache_not_enabled"}}The cache is not currently enabled.{{end}}
+
+{{define "cache_usage"}}Usage:
+	<br/><b>!cache list [page]</b> - Lists the songs in the cache.
+	<br/><b>!cache purge &lt;id|all|older-than 48h&gt;</b> - Removes songs from the cache.
+	<br/><b>!cache prewarm &lt;playlist url&gt;</b> - Downloads a playlist into the cache without queueing it.
+	<br/><b>!cache pin &lt;id&gt;</b> - Keeps a song in the cache.
+	<br/><b>!cache unpin &lt;id&gt;</b> - Allows a pinned song to be removed from the cache again.
+{{end}}
+
+{{define "cache_list"}}Songs in the cache, page <b>{{.Page}}</b> of <b>{{.Pages}}</b> ({{.Total}} in total):{{range .Cached}}
+	<br/>{{.ID}} - "{{.Title}}" ({{printf "%.1f" .Size}} MB, last used {{.Age}} ago, played {{.PlayCount}} time(s){{if .Pinned}}, pinned{{end}})
+{{- end}}{{end}}
+
+{{define "cache_empty"}}The cache is empty.{{end}}
+
+{{define "cache_purged"}}<b>{{.Count}}</b> song(s) ({{printf "%.1f" .Size}} MB) have been removed from the cache.{{end}}
+
+{{define "cache_prewarm_requested"}}The playlist is being looked up, and its songs will be downloaded into the cache in the background.{{end}}
+
+{{define "cache_prewarm_started"}}Downloading the {{.Total}} song(s) in "{{.Playlist}}" into the cache.{{end}}
+
+{{define "cache_prewarm_progress"}}Prewarming "{{.Playlist}}": {{.Count}} of {{.Total}} song(s) done.{{end}}
+
+{{define "cache_prewarm_finished"}}Finished prewarming "{{.Playlist}}": <b>{{.Count}}</b> of {{.Total}} song(s) are in the cache.{{if .Failed}} {{.Failed}} song(s) could not be downloaded.{{end}}{{end}}
+
+{{define "quota"}}An estimated <b>{{.Count}}</b> of {{.Total}} YouTube API quota units have been used today, in {{.Requests}} request(s). {{.CacheHits}} lookup(s) were answered from the metadata cache instead.{{end}}
+
+{{define "not_cached"}}There is no song with the ID {{.ID}} in the cache.{{end}}
+
+{{define "cache_pinned"}}"{{.Title}}" ({{.ID}}) has been pinned, and will not be removed from the cache.{{end}}
+
+{{define "cache_unpinned"}}"{{.Title}}" ({{.ID}}) is no longer pinned, and may be removed from the cache once it expires or the cache is full.{{end}}
+
+{{define "song_added"}}
+	<b>{{.Submitter}}</b> has added "{{.Title}}" to the queue.
+{{end}}
+
+{{define "playlist_added"}}
+	<b>{{.Submitter}}</b> has added the playlist "{{.Playlist}}" to the queue.
+{{end}}
+
+{{define "song_skipped"}}
+	The number of votes required for a skip has been met. <b>Skipping song!</b>
+{{end}}
+
+{{define "playlist_skipped"}}
+	The number of votes required for a skip has been met. <b>Skipping playlist!</b>
+{{end}}
+
+{{define "help"}}<br/>
+	<b>User Commands:</b>
+	<p><b>!help</b> - Displays this help.</p>
+	<p><b>!add</b> - Adds songs to queue.</p>
+	<p><b>!volume</b> - Either tells you the current volume or sets it to a new volume.</p>
+	<p><b>!skip</b> - Casts a vote to skip the current song</p>
+	<p> <b>!skipplaylist</b> - Casts a vote to skip over the current playlist.</p>
+	<p><b>!pause</b> - Pauses the current song.</p>
+	<p><b>!resume</b> - Resumes the paused song.</p>
+	<p><b>!numsongs</b> - Shows how many songs are in queue.</p>
+	<p><b>!nextsong</b> - Shows the title and submitter of the next queue item if it exists.</p>
+	<p><b>!currentsong</b> - Shows the title and submitter of the song currently playing.</p>
+	<p style="-qt-paragraph-type:empty"><br/></p>
+	<p><b>Admin Commands:</b></p>
+	<p><b>!reset</b> - An admin command that resets the song queue. </p>
+	<p><b>!forceskip</b> - An admin command that forces a song skip. </p>
+	<p><b>!forceskipplaylist</b> - An admin command that forces a playlist skip. </p>
+	<p><b>!move </b>- Moves MumbleDJ into channel if it exists.</p>
+	<p><b>!reload</b> - Reloads mumbledj.gcfg configuration settings.</p>
+	<p><b>!setcomment</b> - Sets the comment for the bot.</p>
+	<p><b>!numcached</b></p> - Outputs the number of songs cached on disk.</p>
+	<p><b>!cachesize</b></p> - Outputs the total file size of the cache in MB.</p>
+	<p><b>!cache</b> - Lists, purges, prewarms and pins the songs in the cache.</p>
+	<p><b>!quota</b> - Shows how much YouTube API quota has been used today.</p>
+	<p><b>!kill</b> - Safely cleans the bot environment and disconnects from the server.</p>
+{{end}}
+
+{{define "cur_volume"}}
+	The current volume is <b>{{printf "%.2f" .Volume}}</b>.
+{{end}}
+
+{{define "skip_added"}}
+	<b>{{.User}}</b> has voted to skip the current song.
+{{end}}
+
+{{define "submitter_skip"}}
+	The current song has been skipped by <b>{{.User}}</b>, the submitter.
+{{end}}
+
+{{define "playlist_skip_added"}}
+	<b>{{.User}}</b> has voted to skip the current playlist.
+{{end}}
+
+{{define "playlist_submitter_skip"}}
+	The current playlist has been skipped by <b>{{.User}}</b>, the submitter.
+{{end}}
+
+{{define "volume_success"}}
+	<b>{{.User}}</b> has changed the volume to <b>{{printf "%.2f" .Volume}}</b>.
+{{end}}
+
+{{define "paused"}}
+	<b>{{.User}}</b> has paused the music.
+{{end}}
+
+{{define "resumed"}}
+	<b>{{.User}}</b> has resumed the music.
+{{end}}
+
+{{define "song_removed"}}
+	<b>{{.User}}</b> has removed "{{.Title}}" from the queue.
+{{end}}
+
+{{define "queue_reset"}}
+	<b>{{.User}}</b> has cleared the song queue.
+{{end}}
+
+{{define "num_songs"}}
+	There are currently <b>{{.Count}}</b> song(s) in the queue.
+{{end}}
+
+{{define "next_song"}}
+	The next song in the queue is "{{.Title}}", added by <b>{{.Submitter}}</b>.
+{{end}}
+
+{{define "current_song"}}
+	The song currently playing is "{{.Title}}", added by <b>{{.Submitter}}</b>.
+{{end}}
+
+{{define "current_song_playlist"}}
+	The song currently playing is "{{.Title}}", added <b>{{.Submitter}}</b> from the playlist "{{.Playlist}}".
+{{end}}
+
+{{define "now_playing"}}
+	<table>
+		<tr>
+			<td align="center"><img src="{{.Thumbnail}}" width=150 /></td>
+		</tr>
+		<tr>
+			<td align="center"><b><a href="http://youtu.be/{{.ID}}">{{.Title}}</a> ({{.Duration}})</b></td>
+		</tr>
+		<tr>
+			<td align="center">Added by {{.Submitter}}</td>
+		</tr>
+		{{if .Playlist}}
+		<tr>
+			<td align="center">From playlist "{{.Playlist}}"</td>
+		</tr>
+		{{end}}
+	</table>
+{{end}}
+
+{{define "shutting_down"}}
+	MumbleDJ is shutting down.{{if .Count}} The <b>{{.Count}}</b> song(s) in the queue will be played when it returns.{{end}}
+{{end}}
 `
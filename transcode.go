@@ -0,0 +1,63 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * transcode.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// songFilename returns the name of the file the song with the given ID is stored in, which
+// depends on the Format set in the [Cache] section.
+func songFilename(id string) string {
+	if dj.conf.Cache.Format == "opus" {
+		return id + ".opus"
+	}
+	return id + ".m4a"
+}
+
+// downloadFilename returns the name of the file youtube-dl downloads the song stored in filename
+// to. Songs stored as m4a are downloaded straight into place, while songs stored as Opus are
+// downloaded alongside and then transcoded.
+func downloadFilename(filename string) string {
+	if strings.HasSuffix(filename, ".opus") {
+		return filename + ".source"
+	}
+	return filename
+}
+
+// TranscodeSong converts the song downloaded to downloadFilename(filename) to Ogg/Opus at the
+// Bitrate set in the [Cache] section, storing it in filename. The downloaded file is removed
+// whether or not the conversion succeeds.
+func TranscodeSong(filename string) error {
+	source := songPath(downloadFilename(filename))
+	defer os.Remove(source)
+	cmd := exec.Command("ffmpeg", "-nostdin", "-loglevel", "error", "-y", "-i", source, "-vn",
+		"-c:a", "libopus", "-b:a", fmt.Sprintf("%dk", dj.conf.Cache.Bitrate), "-application", "audio",
+		"-f", "ogg", songPath(filename+".part"))
+	if err := downloads.Run(cmd, filename); err != nil {
+		return errors.New("Song transcoding failed.")
+	}
+	return os.Rename(songPath(filename+".part"), songPath(filename))
+}
+
+// checkOpusEncoder checks that ffmpeg is able to encode Opus, which is needed when songs are
+// stored as Opus.
+func checkOpusEncoder() error {
+	output, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return errors.New("Could not run ffmpeg.")
+	}
+	if !strings.Contains(string(output), "libopus") {
+		return errors.New("The installed ffmpeg was built without libopus.")
+	}
+	return nil
+}
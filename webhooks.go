@@ -0,0 +1,198 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * webhooks.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// webhookEvents are the names of the events that may be listed in the Events variable of the
+// [Webhooks] section.
+var webhookEvents = []string{"song_queued", "playlist_queued", "song_started", "song_finished", "song_skipped", "playlist_skipped"}
+
+// WebhookSong is the JSON representation of a song sent to webhooks.
+type WebhookSong struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	URL       string `json:"url"`
+	Duration  string `json:"duration"`
+	Submitter string `json:"submitter"`
+	Playlist  string `json:"playlist,omitempty"`
+}
+
+// WebhookPlaylist is the JSON representation of a playlist sent to webhooks.
+type WebhookPlaylist struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Songs int    `json:"songs,omitempty"`
+}
+
+// WebhookPayload is the body of the request sent to every webhook URL for an event. Sender is
+// the user whose command caused the event, if any.
+type WebhookPayload struct {
+	Event     string           `json:"event"`
+	Channel   string           `json:"channel"`
+	Sender    string           `json:"sender,omitempty"`
+	Song      *WebhookSong     `json:"song,omitempty"`
+	Playlist  *WebhookPlaylist `json:"playlist,omitempty"`
+	Timestamp time.Time        `json:"timestamp"`
+}
+
+// NewWebhookSong returns the JSON representation of a Song.
+func NewWebhookSong(s Song) *WebhookSong {
+	song := &WebhookSong{
+		ID:        s.ID(),
+		Title:     s.Title(),
+		URL:       fmt.Sprintf("https://youtu.be/%s", s.ID()),
+		Duration:  s.Duration(),
+		Submitter: s.Submitter(),
+	}
+	if s.Playlist() != nil {
+		song.Playlist = s.Playlist().Title()
+	}
+	return song
+}
+
+// NewWebhookPlaylist returns the JSON representation of a Playlist.
+func NewWebhookPlaylist(p Playlist) *WebhookPlaylist {
+	return &WebhookPlaylist{
+		ID:    p.ID(),
+		Title: p.Title(),
+		URL:   fmt.Sprintf("https://www.youtube.com/playlist?list=%s", p.ID()),
+	}
+}
+
+// WebhookNotifier sends the events listed in the [Webhooks] section to every configured URL.
+var WebhookNotifier = Listener{
+	SongQueued: func(e *SongQueuedEvent) {
+		sendWebhooks(&WebhookPayload{Event: "song_queued", Sender: e.Sender.Name(), Song: NewWebhookSong(e.Song)})
+	},
+	PlaylistQueued: func(e *PlaylistQueuedEvent) {
+		playlist := NewWebhookPlaylist(e.Playlist)
+		playlist.Songs = e.Songs
+		sendWebhooks(&WebhookPayload{Event: "playlist_queued", Sender: e.Sender.Name(), Playlist: playlist})
+	},
+	SongStarted: func(e *SongStartedEvent) {
+		sendWebhooks(&WebhookPayload{Event: "song_started", Song: NewWebhookSong(e.Song)})
+	},
+	SongFinished: func(e *SongFinishedEvent) {
+		sendWebhooks(&WebhookPayload{Event: "song_finished", Song: NewWebhookSong(e.Song)})
+	},
+	SongSkipped: func(e *SongSkippedEvent) {
+		sendWebhooks(&WebhookPayload{Event: "song_skipped", Sender: e.Sender.Name(), Song: NewWebhookSong(e.Song)})
+	},
+	PlaylistSkipped: func(e *PlaylistSkippedEvent) {
+		sendWebhooks(&WebhookPayload{Event: "playlist_skipped", Sender: e.Sender.Name(), Playlist: NewWebhookPlaylist(e.Playlist)})
+	},
+}
+
+// sendWebhooks sends payload to every webhook URL if its event is listed in the [Webhooks]
+// section. The payload is encoded straight away, and sent in the background.
+func sendWebhooks(payload *WebhookPayload) {
+	if len(dj.conf.Webhooks.URLs) == 0 || !webhookEventEnabled(payload.Event) {
+		return
+	}
+	if dj.client != nil && dj.client.Self != nil && dj.client.Self.Channel != nil {
+		payload.Channel = dj.client.Self.Channel.Name
+	}
+	payload.Timestamp = time.Now().UTC()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.WithField("event", payload.Event).WithError(err).Error("An error occurred while encoding a webhook.")
+		return
+	}
+
+	conf := dj.conf.Webhooks
+	client := &http.Client{Timeout: time.Duration(conf.Timeout) * time.Second}
+	for _, url := range conf.URLs {
+		go func(url string) {
+			if err := deliverWebhook(client, url, payload.Event, body, conf.Secret, conf.Retries); err != nil {
+				logger.WithFields(logrus.Fields{
+					"event": payload.Event,
+					"url":   url,
+				}).WithError(err).Warn("Could not send a webhook.")
+			}
+		}(url)
+	}
+}
+
+// webhookEventEnabled returns whether event is listed in the [Webhooks] section.
+func webhookEventEnabled(event string) bool {
+	for _, enabled := range dj.conf.Webhooks.Events {
+		if enabled == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookRetryDelay is the wait before the first retry of a webhook request.
+var webhookRetryDelay = time.Second
+
+// deliverWebhook POSTs body to url, trying again up to retries more times if the request fails or
+// the server responds with a 5xx or 429 status. The wait between attempts starts at
+// webhookRetryDelay and doubles after each attempt. If secret is not empty, the request carries an
+// X-MumbleDJ-Signature header with the hex-encoded HMAC-SHA256 of the body.
+func deliverWebhook(client *http.Client, url, event string, body []byte, secret string, retries int) error {
+	var err error
+	wait := webhookRetryDelay
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		var retry bool
+		if retry, err = postWebhook(client, url, event, body, secret); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// postWebhook makes a single webhook request, and returns whether it is worth trying again if it
+// fails.
+func postWebhook(client *http.Client, url, event string, body []byte, secret string) (bool, error) {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "MumbleDJ")
+	request.Header.Set("X-MumbleDJ-Event", event)
+	if secret != "" {
+		request.Header.Set("X-MumbleDJ-Signature", "sha256="+webhookSignature(body, secret))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("The server responded with %s.", response.Status)
+}
+
+// webhookSignature returns the hex-encoded HMAC-SHA256 of body, keyed with secret.
+func webhookSignature(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
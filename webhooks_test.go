@@ -0,0 +1,165 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * webhooks_test.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	webhookRetryDelay = 10 * time.Millisecond
+}
+
+func TestDeliverWebhookSignature(t *testing.T) {
+	body := []byte(`{"event":"song_started"}`)
+	var mutex sync.Mutex
+	var signature, event string
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		signature = r.Header.Get("X-MumbleDJ-Signature")
+		event = r.Header.Get("X-MumbleDJ-Event")
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	if err := deliverWebhook(server.Client(), server.URL, "song_started", body, "secret", 0); err != nil {
+		t.Fatalf("deliverWebhook returned %v", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	// HMAC-SHA256 of the body keyed with "secret".
+	want := "sha256=" + webhookSignature(body, "secret")
+	if signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+	if len(signature) != len("sha256=")+64 {
+		t.Errorf("signature %q is not a hex-encoded SHA-256 MAC", signature)
+	}
+	if event != "song_started" {
+		t.Errorf("event = %q, want %q", event, "song_started")
+	}
+	if string(received) != string(body) {
+		t.Errorf("body = %q, want %q", received, body)
+	}
+}
+
+func TestDeliverWebhookWithoutSecret(t *testing.T) {
+	var signed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-MumbleDJ-Signature") != "" {
+			atomic.StoreInt32(&signed, 1)
+		}
+	}))
+	defer server.Close()
+
+	if err := deliverWebhook(server.Client(), server.URL, "song_started", []byte("{}"), "", 0); err != nil {
+		t.Fatalf("deliverWebhook returned %v", err)
+	}
+	if atomic.LoadInt32(&signed) != 0 {
+		t.Error("request was signed without a secret")
+	}
+}
+
+func TestWebhookSignatureKnownValue(t *testing.T) {
+	// echo -n "hello" | openssl dgst -sha256 -hmac "key"
+	want := "9307b3b915efb5171ff14d8cb55fbcc798c6c0ef1456d66ded1a6aa723a58b7b"
+	if got := webhookSignature([]byte("hello"), "key"); got != want {
+		t.Errorf("webhookSignature = %q, want %q", got, want)
+	}
+}
+
+func TestDeliverWebhookRetriesServerErrors(t *testing.T) {
+	var attempts int32
+	var mutex sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		times = append(times, time.Now())
+		mutex.Unlock()
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	if err := deliverWebhook(server.Client(), server.URL, "song_started", []byte("{}"), "", 3); err != nil {
+		t.Fatalf("deliverWebhook returned %v", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatalf("attempts = %d, want 3", n)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	// The wait doubles after each attempt.
+	if first, second := times[1].Sub(times[0]), times[2].Sub(times[1]); first < webhookRetryDelay || second < 2*webhookRetryDelay {
+		t.Errorf("waits were %v and %v, want at least %v and %v", first, second, webhookRetryDelay, 2*webhookRetryDelay)
+	}
+}
+
+func TestDeliverWebhookGivesUpAfterRetries(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := deliverWebhook(server.Client(), server.URL, "song_started", []byte("{}"), "", 2); err == nil {
+		t.Fatal("deliverWebhook succeeded against a failing server")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("attempts = %d, want 3", n)
+	}
+}
+
+func TestDeliverWebhookDoesNotRetryClientErrors(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	if err := deliverWebhook(server.Client(), server.URL, "song_started", []byte("{}"), "", 3); err == nil {
+		t.Fatal("deliverWebhook succeeded against a 404")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("attempts = %d, want 1", n)
+	}
+}
+
+func TestDeliverWebhookTimeout(t *testing.T) {
+	var attempts int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	started := time.Now()
+	if err := deliverWebhook(client, server.URL, "song_started", []byte("{}"), "", 1); err == nil {
+		t.Fatal("deliverWebhook succeeded against a server that never responds")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("deliverWebhook took %v, want it to time out", elapsed)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Errorf("attempts = %d, want 2, as timeouts are retried", n)
+	}
+}
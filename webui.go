@@ -0,0 +1,385 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * webui.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebState is the playback state shown on the now playing page. It is sent to every connected
+// page as JSON whenever the queue or playback state changes.
+type WebState struct {
+	Playing             bool      `json:"playing"`
+	Paused              bool      `json:"paused"`
+	Song                *APISong  `json:"song"`
+	Elapsed             int       `json:"elapsed"`
+	Skips               int       `json:"skips"`
+	SkipsNeeded         int       `json:"skipsNeeded"`
+	PlaylistSkips       int       `json:"playlistSkips"`
+	PlaylistSkipsNeeded int       `json:"playlistSkipsNeeded"`
+	Queue               []APISong `json:"queue"`
+}
+
+// skipsNeeded returns the number of skip votes needed for the given skip ratio to be reached.
+func skipsNeeded(ratio float32) int {
+	channelUsers := 0
+	if dj.client != nil && dj.client.Self != nil && dj.client.Self.Channel != nil {
+		channelUsers = len(dj.client.Self.Channel.Users)
+	}
+	return int(math.Ceil(float64(ratio) * float64(channelUsers)))
+}
+
+// CurrentWebState returns the current song, upcoming queue and skip votes.
+func CurrentWebState() WebState {
+	state := WebState{
+		Playing: dj.audioStream != nil && dj.audioStream.IsPlaying(),
+		Paused:  dj.paused,
+		Queue:   make([]APISong, 0),
+	}
+	if dj.HasCurrentSong() {
+		current := dj.queue.CurrentSong()
+		song := NewAPISong(current)
+		state.Song = &song
+		state.Elapsed = int(dj.Elapsed().Seconds())
+		state.Skips = current.Skips()
+		state.SkipsNeeded = skipsNeeded(dj.conf.General.SkipRatio)
+		if current.Playlist() != nil {
+			state.PlaylistSkips = current.Playlist().Skips()
+			state.PlaylistSkipsNeeded = skipsNeeded(dj.conf.General.PlaylistSkipRatio)
+		}
+	}
+	dj.queue.Traverse(func(i int, song Song) {
+		if i > 0 || !dj.HasCurrentSong() {
+			state.Queue = append(state.Queue, NewAPISong(song))
+		}
+	})
+	return state
+}
+
+// webClient is a now playing page connected over WebSocket.
+type webClient struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// webHub keeps track of the connected now playing pages and sends them the playback state.
+type webHub struct {
+	mutex   sync.Mutex
+	clients map[*webClient]bool
+	notify  chan bool
+	running bool
+}
+
+// webClients holds every now playing page connected to MumbleDJ.
+var webClients = &webHub{
+	clients: make(map[*webClient]bool),
+	notify:  make(chan bool, 1),
+}
+
+// NotifyWebClients sends the current playback state to every connected now playing page. It is
+// called whenever the queue or playback state changes, and never blocks: changes that happen in
+// quick succession are sent as a single update.
+func NotifyWebClients() {
+	select {
+	case webClients.notify <- true:
+	default:
+	}
+}
+
+// start attaches the hub to dj.events and begins sending updates to the connected pages, unless
+// this is already being done.
+func (h *webHub) start() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if !h.running {
+		h.running = true
+		dj.events.Attach(h)
+		go h.run()
+	}
+}
+
+// OnSongQueued updates the now playing pages after a song has been added to the queue.
+func (h *webHub) OnSongQueued(e *SongQueuedEvent) {
+	NotifyWebClients()
+}
+
+// OnPlaylistQueued updates the now playing pages after a playlist has been added to the queue.
+func (h *webHub) OnPlaylistQueued(e *PlaylistQueuedEvent) {
+	NotifyWebClients()
+}
+
+// OnSongRemoved updates the now playing pages after a song has been removed from the queue.
+func (h *webHub) OnSongRemoved(e *SongRemovedEvent) {
+	NotifyWebClients()
+}
+
+// OnSongStarted updates the now playing pages after a song has started playing.
+func (h *webHub) OnSongStarted(e *SongStartedEvent) {
+	NotifyWebClients()
+}
+
+// OnSongFinished updates the now playing pages after a song has finished playing.
+func (h *webHub) OnSongFinished(e *SongFinishedEvent) {
+	NotifyWebClients()
+}
+
+// OnSkipVoted updates the now playing pages after a user has voted to skip.
+func (h *webHub) OnSkipVoted(e *SkipVotedEvent) {
+	NotifyWebClients()
+}
+
+// OnSongSkipped updates the now playing pages after a song has been skipped.
+func (h *webHub) OnSongSkipped(e *SongSkippedEvent) {
+	NotifyWebClients()
+}
+
+// OnPlaylistSkipped updates the now playing pages after a playlist has been skipped.
+func (h *webHub) OnPlaylistSkipped(e *PlaylistSkippedEvent) {
+	NotifyWebClients()
+}
+
+// OnPlaybackPaused updates the now playing pages after playback has been paused.
+func (h *webHub) OnPlaybackPaused(e *PlaybackPausedEvent) {
+	NotifyWebClients()
+}
+
+// OnPlaybackResumed updates the now playing pages after playback has been resumed.
+func (h *webHub) OnPlaybackResumed(e *PlaybackResumedEvent) {
+	NotifyWebClients()
+}
+
+// OnVolumeChanged updates the now playing pages after the volume has changed.
+func (h *webHub) OnVolumeChanged(e *VolumeChangedEvent) {
+	NotifyWebClients()
+}
+
+// OnQueueReset updates the now playing pages after the queue has been reset.
+func (h *webHub) OnQueueReset(e *QueueResetEvent) {
+	NotifyWebClients()
+}
+
+// OnDownloadFailed updates the now playing pages after a song could not be downloaded.
+func (h *webHub) OnDownloadFailed(e *DownloadFailedEvent) {
+	NotifyWebClients()
+}
+
+// run waits for notifications and sends the playback state to every connected page.
+func (h *webHub) run() {
+	for range h.notify {
+		commandMutex.Lock()
+		state := CurrentWebState()
+		commandMutex.Unlock()
+
+		message, err := json.Marshal(state)
+		if err != nil {
+			logger.WithError(err).Error("An error occurred while encoding the now playing state.")
+			continue
+		}
+		h.mutex.Lock()
+		for client := range h.clients {
+			select {
+			case client.send <- message:
+			default:
+				// The page is not keeping up, so it will receive the next update instead.
+			}
+		}
+		h.mutex.Unlock()
+	}
+}
+
+// add registers a connected page.
+func (h *webHub) add(client *webClient) {
+	h.mutex.Lock()
+	h.clients[client] = true
+	h.mutex.Unlock()
+}
+
+// remove unregisters a page once its connection has closed.
+func (h *webHub) remove(client *webClient) {
+	h.mutex.Lock()
+	if h.clients[client] {
+		delete(h.clients, client)
+		close(client.send)
+	}
+	h.mutex.Unlock()
+}
+
+// webUpgrader upgrades requests to /ws into WebSocket connections. Connections from pages served
+// by other sites are refused.
+var webUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// serveWebSocket accepts a WebSocket connection from the now playing page and sends it the
+// playback state whenever it changes.
+func serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := webUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	client := &webClient{
+		conn: conn,
+		send: make(chan []byte, 8),
+	}
+	webClients.add(client)
+	NotifyWebClients()
+
+	go func() {
+		defer conn.Close()
+		for message := range client.send {
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				webClients.remove(client)
+			}
+		}
+	}()
+
+	// The page never sends anything, but reading is required to notice when it disconnects.
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			webClients.remove(client)
+			return
+		}
+	}
+}
+
+// serveNowPlayingPage serves the now playing page.
+func serveNowPlayingPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, nowPlayingPage)
+}
+
+// nowPlayingPage is the HTML for the now playing page. It connects back to /ws and renders each
+// playback state it receives, counting up the elapsed time between updates.
+const nowPlayingPage = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>MumbleDJ</title>
+	<style>
+		body { font-family: sans-serif; background: #222; color: #eee; margin: 0; padding: 2em; }
+		main { max-width: 40em; margin: 0 auto; }
+		a { color: #8cf; }
+		img { max-width: 100%; border-radius: 4px; }
+		progress { width: 100%; }
+		ol { padding-left: 1.5em; }
+		li { margin-bottom: 0.5em; }
+		.muted { color: #999; }
+		#status { float: right; }
+	</style>
+</head>
+<body>
+<main>
+	<span id="status" class="muted">Connecting...</span>
+	<h1>Now Playing</h1>
+	<div id="nothing" class="muted">There is no music playing at the moment.</div>
+	<div id="current" hidden>
+		<img id="thumbnail" alt="">
+		<h2><a id="title" target="_blank"></a></h2>
+		<p>Added by <b id="submitter"></b><span id="playlist"></span></p>
+		<progress id="progress" max="1" value="0"></progress>
+		<p><span id="elapsed"></span> / <span id="duration"></span> <span id="paused" class="muted" hidden>(paused)</span></p>
+		<p>Skip votes: <span id="skips"></span><span id="playlistskips"></span></p>
+	</div>
+	<h2>Up Next</h2>
+	<ol id="queue"></ol>
+	<div id="empty" class="muted">There are no songs queued at the moment.</div>
+</main>
+<script>
+(function() {
+	var state = null;
+	var received = 0;
+
+	function $(id) { return document.getElementById(id); }
+
+	function seconds(duration) {
+		return duration.split(":").reduce(function(total, part) { return total * 60 + parseInt(part, 10); }, 0);
+	}
+
+	function format(total) {
+		var hours = Math.floor(total / 3600), minutes = Math.floor(total / 60) % 60, secs = total % 60;
+		var text = (hours > 0 ? hours + ":" + (minutes < 10 ? "0" : "") : "") + minutes + ":";
+		return text + (secs < 10 ? "0" : "") + secs;
+	}
+
+	function tick() {
+		if (!state || !state.song) {
+			return;
+		}
+		var elapsed = state.elapsed;
+		if (state.playing) {
+			elapsed += Math.floor((Date.now() - received) / 1000);
+		}
+		var total = seconds(state.song.duration);
+		elapsed = Math.min(elapsed, total);
+		$("elapsed").textContent = format(elapsed);
+		$("progress").value = total > 0 ? elapsed / total : 0;
+	}
+
+	function render() {
+		$("nothing").hidden = !!state.song;
+		$("current").hidden = !state.song;
+		if (state.song) {
+			$("thumbnail").src = state.song.thumbnail;
+			$("title").textContent = state.song.title;
+			$("title").href = "https://youtu.be/" + state.song.id;
+			$("submitter").textContent = state.song.submitter;
+			$("playlist").textContent = state.song.playlist ? " from the playlist \"" + state.song.playlist + "\"" : "";
+			$("duration").textContent = state.song.duration;
+			$("paused").hidden = !state.paused;
+			$("skips").textContent = state.skips + " of " + state.skipsNeeded + " for the song";
+			$("playlistskips").textContent = state.song.playlist ?
+				", " + state.playlistSkips + " of " + state.playlistSkipsNeeded + " for the playlist" : "";
+		}
+		var queue = $("queue");
+		while (queue.firstChild) {
+			queue.removeChild(queue.firstChild);
+		}
+		state.queue.forEach(function(song) {
+			var item = document.createElement("li");
+			item.textContent = song.title + " (" + song.duration + "), added by " + song.submitter;
+			queue.appendChild(item);
+		});
+		$("empty").hidden = state.queue.length > 0;
+		tick();
+	}
+
+	function connect() {
+		var socket = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+		socket.onopen = function() { $("status").textContent = "Live"; };
+		socket.onmessage = function(event) {
+			state = JSON.parse(event.data);
+			received = Date.now();
+			render();
+		};
+		socket.onclose = function() {
+			$("status").textContent = "Reconnecting...";
+			setTimeout(connect, 5000);
+		};
+	}
+
+	setInterval(tick, 1000);
+	connect();
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,266 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * youtubeapi.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of errors returned by the YouTube Data API client. Errors returned by the client wrap one
+// of these, so they may be checked with errors.Is. ErrInvalidAPIKey is a kind of ErrForbidden, for
+// requests refused because of the API key rather than because of what was asked for.
+var (
+	ErrQuotaExceeded = errors.New("The YouTube API quota has been exceeded.")
+	ErrNotFound      = errors.New("Invalid YouTube ID supplied.")
+	ErrForbidden     = errors.New("Access to the YouTube video or playlist is forbidden.")
+	ErrInvalidAPIKey = errors.New("Invalid API key supplied.")
+	ErrNetwork       = errors.New("An error occurred while contacting the YouTube API.")
+)
+
+// YouTubeAPIError describes a failed request to the YouTube Data API. Kind is one of the Err
+// values above, and Status, Reason and Message hold what the API returned, if anything.
+type YouTubeAPIError struct {
+	Kind    error
+	Status  int
+	Reason  string
+	Message string
+}
+
+// Error returns the kind of error, followed by the message returned by the API.
+func (e *YouTubeAPIError) Error() string {
+	if e.Message == "" {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s (%s)", e.Kind.Error(), e.Message)
+}
+
+// Unwrap returns the kind of error, so that it may be checked with errors.Is.
+func (e *YouTubeAPIError) Unwrap() error {
+	return e.Kind
+}
+
+// Is reports whether the error is an ErrInvalidAPIKey when checked against ErrForbidden.
+func (e *YouTubeAPIError) Is(target error) bool {
+	return target == ErrForbidden && e.Kind == ErrInvalidAPIKey
+}
+
+// youtubeAPIErrorLabel returns the name of the kind of err, for use in metrics.
+func youtubeAPIErrorLabel(err error) string {
+	switch {
+	case errors.Is(err, ErrQuotaExceeded):
+		return "quota"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	}
+	return "network"
+}
+
+// apiThumbnail is a thumbnail of a video or playlist.
+type apiThumbnail struct {
+	URL string `json:"url"`
+}
+
+// apiVideo is a video as returned by videos.list.
+type apiVideo struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Title        string                  `json:"title"`
+		ChannelTitle string                  `json:"channelTitle"`
+		Thumbnails   map[string]apiThumbnail `json:"thumbnails"`
+	} `json:"snippet"`
+	ContentDetails struct {
+		Duration string `json:"duration"`
+	} `json:"contentDetails"`
+}
+
+// apiPlaylist is a playlist as returned by playlists.list.
+type apiPlaylist struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Title        string `json:"title"`
+		ChannelTitle string `json:"channelTitle"`
+	} `json:"snippet"`
+}
+
+// apiPlaylistItem is a video in a playlist as returned by playlistItems.list.
+type apiPlaylistItem struct {
+	Snippet struct {
+		Title      string                  `json:"title"`
+		Thumbnails map[string]apiThumbnail `json:"thumbnails"`
+		ResourceID struct {
+			VideoID string `json:"videoId"`
+		} `json:"resourceId"`
+	} `json:"snippet"`
+}
+
+// apiSearchResult is a video found by search.list.
+type apiSearchResult struct {
+	ID struct {
+		VideoID string `json:"videoId"`
+	} `json:"id"`
+	Snippet struct {
+		Title        string                  `json:"title"`
+		ChannelTitle string                  `json:"channelTitle"`
+		Thumbnails   map[string]apiThumbnail `json:"thumbnails"`
+	} `json:"snippet"`
+}
+
+// apiErrorResponse is the body returned by the API when a request fails.
+type apiErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Errors  []struct {
+			Reason string `json:"reason"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// YouTubeAPI is a client for the YouTube Data API. BaseURL is the address the API is found at,
+// which may be changed to point the client at another server.
+type YouTubeAPI struct {
+	BaseURL string
+	Key     string
+	Client  *http.Client
+}
+
+// NewYouTubeAPI creates a client for the YouTube Data API using the APIKey, APIBaseURL and
+// APITimeout set in the [YouTube] section of conf.
+func NewYouTubeAPI(conf DjConfig) *YouTubeAPI {
+	return &YouTubeAPI{
+		BaseURL: strings.TrimSuffix(conf.YouTube.APIBaseURL, "/"),
+		Key:     conf.YouTube.APIKey,
+		Client:  &http.Client{Timeout: time.Duration(conf.YouTube.APITimeout) * time.Second},
+	}
+}
+
+// Videos returns the videos with the given IDs. Videos that do not exist or are private are
+// left out, so fewer videos than IDs may be returned.
+func (c *YouTubeAPI) Videos(ids ...string) ([]apiVideo, error) {
+	var response struct {
+		Items []apiVideo `json:"items"`
+	}
+	params := url.Values{"part": {"snippet,contentDetails"}, "id": {strings.Join(ids, ",")}}
+	if err := c.get("videos", params, &response); err != nil {
+		return nil, err
+	}
+	return response.Items, nil
+}
+
+// Playlist returns the playlist with the given ID.
+func (c *YouTubeAPI) Playlist(id string) (apiPlaylist, error) {
+	var response struct {
+		Items []apiPlaylist `json:"items"`
+	}
+	params := url.Values{"part": {"snippet"}, "id": {id}}
+	if err := c.get("playlists", params, &response); err != nil {
+		return apiPlaylist{}, err
+	}
+	if len(response.Items) == 0 {
+		return apiPlaylist{}, &YouTubeAPIError{Kind: ErrNotFound}
+	}
+	return response.Items[0], nil
+}
+
+// PlaylistItems returns at most max of the first videos in the playlist with the given ID.
+func (c *YouTubeAPI) PlaylistItems(id string, max int) ([]apiPlaylistItem, error) {
+	var response struct {
+		Items []apiPlaylistItem `json:"items"`
+	}
+	params := url.Values{"part": {"snippet"}, "playlistId": {id}, "maxResults": {strconv.Itoa(max)}}
+	if err := c.get("playlistItems", params, &response); err != nil {
+		return nil, err
+	}
+	return response.Items, nil
+}
+
+// Search returns at most max videos matching query. Each search costs 100 quota units.
+func (c *YouTubeAPI) Search(query string, max int) ([]apiSearchResult, error) {
+	var response struct {
+		Items []apiSearchResult `json:"items"`
+	}
+	params := url.Values{"part": {"snippet"}, "type": {"video"}, "q": {query}, "maxResults": {strconv.Itoa(max)}}
+	if err := c.get("search", params, &response); err != nil {
+		return nil, err
+	}
+	return response.Items, nil
+}
+
+// get requests endpoint with params and decodes the response into result. The quota used by the
+// request is recorded, and failures are returned as a YouTubeAPIError.
+func (c *YouTubeAPI) get(endpoint string, params url.Values, result interface{}) error {
+	params.Set("key", c.Key)
+	youtubeRequests.Inc()
+	dj.metadata.UseQuota(youtubeQuotaCost(endpoint))
+
+	err := c.request(fmt.Sprintf("%s/%s?%s", c.BaseURL, endpoint, params.Encode()), result)
+	if err != nil {
+		youtubeErrors.WithLabelValues(youtubeAPIErrorLabel(err)).Inc()
+	}
+	return err
+}
+
+// request performs a GET request to address and decodes the JSON response into result.
+func (c *YouTubeAPI) request(address string, result interface{}) error {
+	response, err := c.Client.Get(address)
+	if err != nil {
+		// The error would otherwise include the address, which holds the API key.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return &YouTubeAPIError{Kind: ErrNetwork, Message: err.Error()}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return youtubeAPIError(response.StatusCode, response.Body)
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return &YouTubeAPIError{Kind: ErrNetwork, Status: response.StatusCode, Message: "The response could not be decoded: " + err.Error()}
+	}
+	return nil
+}
+
+// youtubeAPIError returns the error for a failed request given the status code and body of the response.
+func youtubeAPIError(status int, body io.Reader) *YouTubeAPIError {
+	apiErr := &YouTubeAPIError{Status: status}
+	var response apiErrorResponse
+	if contents, err := ioutil.ReadAll(io.LimitReader(body, 1<<16)); err == nil && json.Unmarshal(contents, &response) == nil {
+		apiErr.Message = response.Error.Message
+		if len(response.Error.Errors) > 0 {
+			apiErr.Reason = response.Error.Errors[0].Reason
+		}
+	}
+
+	switch {
+	case apiErr.Reason == "quotaExceeded" || apiErr.Reason == "dailyLimitExceeded" || apiErr.Reason == "rateLimitExceeded":
+		apiErr.Kind = ErrQuotaExceeded
+	case apiErr.Reason == "keyInvalid" || apiErr.Reason == "keyExpired":
+		apiErr.Kind = ErrInvalidAPIKey
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		apiErr.Kind = ErrForbidden
+	case status == http.StatusBadRequest || status == http.StatusNotFound:
+		apiErr.Kind = ErrNotFound
+	case status == http.StatusTooManyRequests:
+		apiErr.Kind = ErrQuotaExceeded
+	default:
+		apiErr.Kind = ErrNetwork
+	}
+	return apiErr
+}
@@ -0,0 +1,198 @@
+/*
+ * MumbleDJ
+ * By Matthieu Grieger
+ * youtubeapi_test.go
+ * Copyright (c) 2014, 2015 Matthieu Grieger (MIT License)
+ */
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestYouTubeAPI returns a client for a stand-in YouTube Data API served by handler, with the
+// metadata cache kept in a temporary state directory.
+func newTestYouTubeAPI(t *testing.T, handler http.HandlerFunc) *YouTubeAPI {
+	state, err := ioutil.TempDir("", "mumbledj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		server.Close()
+		os.RemoveAll(state)
+	})
+	dj.paths = StoragePaths{State: state}
+	dj.conf = defaultConfiguration()
+	dj.metadata = NewMetadataCache()
+	return &YouTubeAPI{BaseURL: server.URL, Key: "secret-key", Client: &http.Client{Timeout: time.Second}}
+}
+
+// apiErrorHandler responds to every request with status and a Google API error with reason.
+func apiErrorHandler(status int, reason string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":{"code":` + strconv.Itoa(status) + `,"message":"` + reason + ` happened","errors":[{"reason":"` + reason + `"}]}}`))
+	}
+}
+
+func TestYouTubeAPIErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    error
+		message string
+	}{
+		{"quota exceeded", apiErrorHandler(http.StatusForbidden, "quotaExceeded"), ErrQuotaExceeded, YOUTUBE_QUOTA_EXCEEDED_MSG},
+		{"daily limit exceeded", apiErrorHandler(http.StatusForbidden, "dailyLimitExceeded"), ErrQuotaExceeded, YOUTUBE_QUOTA_EXCEEDED_MSG},
+		{"too many requests", apiErrorHandler(http.StatusTooManyRequests, ""), ErrQuotaExceeded, YOUTUBE_QUOTA_EXCEEDED_MSG},
+		{"invalid key", apiErrorHandler(http.StatusBadRequest, "keyInvalid"), ErrInvalidAPIKey, INVALID_API_KEY},
+		{"expired key", apiErrorHandler(http.StatusForbidden, "keyExpired"), ErrInvalidAPIKey, INVALID_API_KEY},
+		{"private playlist", apiErrorHandler(http.StatusForbidden, "playlistItemsNotAccessible"), ErrForbidden, YOUTUBE_FORBIDDEN_MSG},
+		{"not found", apiErrorHandler(http.StatusNotFound, "videoNotFound"), ErrNotFound, INVALID_YOUTUBE_ID_MSG},
+		{"server error", apiErrorHandler(http.StatusInternalServerError, "backendError"), ErrNetwork, YOUTUBE_UNAVAILABLE_MSG},
+		{"bad JSON", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"items": [`))
+		}, ErrNetwork, YOUTUBE_UNAVAILABLE_MSG},
+	}
+	for _, test := range tests {
+		api := newTestYouTubeAPI(t, test.handler)
+		_, err := api.Videos("abc")
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+			continue
+		}
+		if message := youtubeErrorMessage(err); message != test.message {
+			t.Errorf("%s: message = %q, want %q", test.name, message, test.message)
+		}
+	}
+}
+
+func TestYouTubeAPIForbiddenIsNotAKeyError(t *testing.T) {
+	api := newTestYouTubeAPI(t, apiErrorHandler(http.StatusForbidden, "playlistItemsNotAccessible"))
+	_, err := api.PlaylistItems("PL123", 25)
+	if errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("a private playlist was reported as an invalid API key: %v", err)
+	}
+	api = newTestYouTubeAPI(t, apiErrorHandler(http.StatusBadRequest, "keyInvalid"))
+	if _, err = api.Videos("abc"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("an invalid API key is not a kind of ErrForbidden: %v", err)
+	}
+}
+
+func TestYouTubeAPINetworkError(t *testing.T) {
+	api := newTestYouTubeAPI(t, func(w http.ResponseWriter, r *http.Request) {})
+	// Nothing listens on the stand-in's address once it has been closed.
+	listener := httptest.NewServer(http.NotFoundHandler())
+	api.BaseURL = listener.URL
+	listener.Close()
+
+	_, err := api.Videos("abc")
+	if !errors.Is(err, ErrNetwork) {
+		t.Fatalf("got %v, want %v", err, ErrNetwork)
+	}
+	if strings.Contains(err.Error(), api.Key) {
+		t.Errorf("the error %q includes the API key", err)
+	}
+	if message := youtubeErrorMessage(err); message != YOUTUBE_UNAVAILABLE_MSG {
+		t.Errorf("message = %q, want %q", message, YOUTUBE_UNAVAILABLE_MSG)
+	}
+}
+
+func TestYouTubeAPITimeout(t *testing.T) {
+	release := make(chan struct{})
+	api := newTestYouTubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer close(release)
+	api.Client.Timeout = 50 * time.Millisecond
+
+	if _, err := api.Videos("abc"); !errors.Is(err, ErrNetwork) {
+		t.Errorf("got %v, want %v", err, ErrNetwork)
+	}
+}
+
+func TestYouTubeAPIVideos(t *testing.T) {
+	var query string
+	api := newTestYouTubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/videos" {
+			http.NotFound(w, r)
+			return
+		}
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"items":[{"id":"abc","snippet":{"title":"A song","channelTitle":"A channel",` +
+			`"thumbnails":{"high":{"url":"https://i.ytimg.com/vi/abc/hqdefault.jpg"}}},` +
+			`"contentDetails":{"duration":"PT1H3M25S"}}]}`))
+	})
+
+	videos, err := api.Videos("abc", "def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 1 {
+		t.Fatalf("got %d videos, want 1", len(videos))
+	}
+	video := videos[0].video()
+	if video.ID != "abc" || video.Title != "A song" || video.Channel != "A channel" || video.Duration != 3805 ||
+		video.Thumbnail != "https://i.ytimg.com/vi/abc/hqdefault.jpg" {
+		t.Errorf("got %+v", video)
+	}
+	if !strings.Contains(query, "id=abc%2Cdef") || !strings.Contains(query, "key=secret-key") {
+		t.Errorf("query = %q", query)
+	}
+}
+
+func TestYouTubeAPIPlaylistNotFound(t *testing.T) {
+	api := newTestYouTubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[]}`))
+	})
+	if _, err := api.Playlist("PL123"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestYouTubeAPISearchQuota(t *testing.T) {
+	api := newTestYouTubeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[{"id":{"videoId":"abc"},"snippet":{"title":"A song"}}]}`))
+	})
+	results, err := api.Search("a song", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID.VideoID != "abc" {
+		t.Errorf("got %+v", results)
+	}
+	if units := dj.metadata.Quota().Units; units != 100 {
+		t.Errorf("a search used %d quota units, want 100", units)
+	}
+}
+
+func TestYouTubeErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{ErrSongTooLong, VIDEO_TOO_LONG_MSG},
+		{&YouTubeAPIError{Kind: ErrQuotaExceeded}, YOUTUBE_QUOTA_EXCEEDED_MSG},
+		{&YouTubeAPIError{Kind: ErrInvalidAPIKey}, INVALID_API_KEY},
+		{&YouTubeAPIError{Kind: ErrForbidden}, YOUTUBE_FORBIDDEN_MSG},
+		{&YouTubeAPIError{Kind: ErrNotFound}, INVALID_YOUTUBE_ID_MSG},
+		{&YouTubeAPIError{Kind: ErrNetwork}, YOUTUBE_UNAVAILABLE_MSG},
+		{ErrNetwork, YOUTUBE_UNAVAILABLE_MSG},
+		{errors.New("Something else."), INVALID_YOUTUBE_ID_MSG},
+	}
+	for _, test := range tests {
+		if got := youtubeErrorMessage(test.err); got != test.want {
+			t.Errorf("youtubeErrorMessage(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+}